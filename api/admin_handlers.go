@@ -0,0 +1,314 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bongo/golang-learnings/models"
+	"github.com/bongo/golang-learnings/services"
+	"github.com/gorilla/mux"
+)
+
+// ListUsers returns a page of users, searchable by username or email
+func (api *API) ListUsers(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	page, _ := strconv.Atoi(query.Get("page"))
+	limit, _ := strconv.Atoi(query.Get("limit"))
+
+	users, err := api.adminService.ListUsers(r.Context(), query.Get("q"), page, limit)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to retrieve users")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, users)
+}
+
+// DisableUser disables a user account
+func (api *API) DisableUser(w http.ResponseWriter, r *http.Request) {
+	api.setUserDisabled(w, r, true)
+}
+
+// EnableUser re-enables a disabled user account
+func (api *API) EnableUser(w http.ResponseWriter, r *http.Request) {
+	api.setUserDisabled(w, r, false)
+}
+
+// setUserDisabled updates the disabled flag of the user in the route
+func (api *API) setUserDisabled(w http.ResponseWriter, r *http.Request, disabled bool) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	user, err := api.adminService.SetUserDisabled(r.Context(), id, disabled, extractUserID(r))
+	if err != nil {
+		if errors.Is(err, services.ErrUserNotFound) {
+			respondError(w, http.StatusNotFound, "User not found")
+			return
+		}
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, user)
+}
+
+// ResetUserPassword sets a new password for a user, generating a temporary one if none is given
+func (api *API) ResetUserPassword(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	// The body is optional
+	var input models.PasswordResetInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil && !errors.Is(err, io.EOF) {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	user, temporary, err := api.authService.ResetPassword(r.Context(), id, input.Password)
+	if err != nil {
+		if errors.Is(err, services.ErrUserNotFound) {
+			respondError(w, http.StatusNotFound, "User not found")
+			return
+		}
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, models.PasswordResetResponse{
+		User:              *user,
+		TemporaryPassword: temporary,
+	})
+}
+
+// ListContacts returns a page of contact messages, searchable by name, email or message
+// text and filterable by date received, read state, status and assignee
+func (api *API) ListContacts(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := models.ContactFilter{Search: query.Get("q"), Status: query.Get("status")}
+	filter.Page, _ = strconv.Atoi(query.Get("page"))
+	filter.Limit, _ = strconv.Atoi(query.Get("limit"))
+
+	var err error
+	if filter.Since, err = parseDateParam(query.Get("from"), false); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid from date, use YYYY-MM-DD or RFC 3339")
+		return
+	}
+	if filter.Until, err = parseDateParam(query.Get("to"), true); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid to date, use YYYY-MM-DD or RFC 3339")
+		return
+	}
+	if v := query.Get("read"); v != "" {
+		read, err := strconv.ParseBool(v)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, "Invalid read filter, use true or false")
+			return
+		}
+		filter.Read = &read
+	}
+
+	if filter.Status != "" && !models.IsValidContactStatus(filter.Status) {
+		respondError(w, http.StatusBadRequest, "Invalid status filter")
+		return
+	}
+	if v := query.Get("assignee"); v != "" {
+		assigneeID, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, "Invalid assignee filter, use a user ID or 0 for unassigned")
+			return
+		}
+		filter.AssigneeID = &assigneeID
+	}
+
+	contacts, err := api.contactService.ListContacts(r.Context(), &filter)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to retrieve contact messages")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, contacts)
+}
+
+// GetContact returns a single contact message
+func (api *API) GetContact(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid contact message ID")
+		return
+	}
+
+	contact, err := api.contactService.GetContact(r.Context(), id)
+	if err != nil {
+		respondContactError(w, err, "Failed to retrieve contact message")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, contact)
+}
+
+// MarkContactRead marks a contact message as read
+func (api *API) MarkContactRead(w http.ResponseWriter, r *http.Request) {
+	api.setContactRead(w, r, true)
+}
+
+// MarkContactUnread marks a contact message as unread
+func (api *API) MarkContactUnread(w http.ResponseWriter, r *http.Request) {
+	api.setContactRead(w, r, false)
+}
+
+// setContactRead updates the read state of the contact message in the route
+func (api *API) setContactRead(w http.ResponseWriter, r *http.Request, read bool) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid contact message ID")
+		return
+	}
+
+	contact, err := api.contactService.SetContactRead(r.Context(), id, read)
+	if err != nil {
+		respondContactError(w, err, "Failed to update contact message")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, contact)
+}
+
+// UpdateContact changes the status and/or assignee of a contact message
+func (api *API) UpdateContact(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid contact message ID")
+		return
+	}
+
+	var input models.ContactUpdateInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	contact, err := api.contactService.UpdateContact(r.Context(), id, &input)
+	if err != nil {
+		if errors.Is(err, services.ErrContactNotFound) {
+			respondError(w, http.StatusNotFound, "Contact message not found")
+			return
+		}
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, contact)
+}
+
+// AddContactNote adds an internal note to a contact message
+func (api *API) AddContactNote(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid contact message ID")
+		return
+	}
+
+	var input models.ContactNoteInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	note, err := api.contactService.AddNote(r.Context(), id, extractUserID(r), &input)
+	if err != nil {
+		if errors.Is(err, services.ErrContactNotFound) {
+			respondError(w, http.StatusNotFound, "Contact message not found")
+			return
+		}
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, note)
+}
+
+// ReplyToContact emails a reply to the sender of a contact message
+func (api *API) ReplyToContact(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid contact message ID")
+		return
+	}
+
+	var input models.ContactReplyInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	reply, err := api.contactService.Reply(r.Context(), id, extractUserID(r), &input)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrContactNotFound):
+			respondError(w, http.StatusNotFound, "Contact message not found")
+		case errors.Is(err, services.ErrReplyNotSent):
+			requestLogger(r).Error("Error sending reply to contact message", "contact_id", id, "error", err)
+			respondError(w, http.StatusBadGateway, "Failed to send reply")
+		default:
+			respondError(w, http.StatusBadRequest, err.Error())
+		}
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, reply)
+}
+
+// DeleteContact permanently deletes a contact message
+func (api *API) DeleteContact(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid contact message ID")
+		return
+	}
+
+	if err := api.contactService.DeleteContact(r.Context(), id); err != nil {
+		respondContactError(w, err, "Failed to delete contact message")
+		return
+	}
+
+	respondJSON(w, http.StatusNoContent, nil)
+}
+
+// respondContactError maps contact service errors to responses
+func respondContactError(w http.ResponseWriter, err error, message string) {
+	if errors.Is(err, services.ErrContactNotFound) {
+		respondError(w, http.StatusNotFound, "Contact message not found")
+		return
+	}
+	respondError(w, http.StatusInternalServerError, message)
+}
+
+// parseDateParam parses a date filter given as YYYY-MM-DD or RFC 3339. A bare date used as
+// an upper bound covers the whole day.
+func parseDateParam(value string, endOfDay bool) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return &t, nil
+	}
+
+	t, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		return nil, err
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+	return &t, nil
+}
@@ -0,0 +1,69 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/bongo/golang-learnings/models"
+	"github.com/bongo/golang-learnings/services"
+	"github.com/gorilla/mux"
+)
+
+// ListAPIKeys returns the authenticated user's API keys
+func (api *API) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	userID := extractUserID(r)
+
+	keys, err := api.apiKeyService.ListAPIKeys(r.Context(), userID)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to retrieve API keys")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, keys)
+}
+
+// CreateAPIKey creates a new API key and returns the plaintext key once
+func (api *API) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	userID := extractUserID(r)
+
+	var input models.APIKeyInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	key, plaintext, err := api.apiKeyService.CreateAPIKey(r.Context(), &input, userID, extractScopes(r))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, models.APIKeyResponse{
+		Key:    plaintext,
+		APIKey: *key,
+	})
+}
+
+// RevokeAPIKey deletes one of the authenticated user's API keys
+func (api *API) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid API key ID")
+		return
+	}
+
+	userID := extractUserID(r)
+
+	if err := api.apiKeyService.RevokeAPIKey(r.Context(), id, userID); err != nil {
+		if errors.Is(err, services.ErrAPIKeyNotFound) {
+			respondError(w, http.StatusNotFound, "API key not found")
+			return
+		}
+		respondError(w, http.StatusInternalServerError, "Failed to revoke API key")
+		return
+	}
+
+	respondJSON(w, http.StatusNoContent, nil)
+}
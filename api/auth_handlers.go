@@ -2,9 +2,17 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
+	"log/slog"
+	"math"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/bongo/golang-learnings/models"
+	"github.com/bongo/golang-learnings/services"
 )
 
 // Register handles user registration
@@ -22,7 +30,7 @@ func (api *API) Register(w http.ResponseWriter, r *http.Request) {
 	}
 	
 	// Register the user using auth service
-	user, err := api.authService.RegisterUser(&input)
+	user, err := api.authService.RegisterUser(r.Context(), &input)
 	if err != nil {
 		respondError(w, http.StatusBadRequest, err.Error())
 		return
@@ -35,11 +43,16 @@ func (api *API) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	
-	// Return the token and user info
-	respondJSON(w, http.StatusCreated, models.AuthResponse{
+	// Move tasks created as a guest into the new account
+	response := models.AuthResponse{
 		Token: token,
 		User:  *user,
-	})
+	}
+	api.claimGuestTasks(w, r, input.ClaimToken, &response)
+	api.startSession(w, &response)
+	
+	// Return the token and user info
+	respondJSON(w, http.StatusCreated, response)
 }
 
 // Login handles user login
@@ -50,13 +63,30 @@ func (api *API) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	
+	// Refuse attempts while the account or client IP is backing off
+	identifier := models.NormalizeIdentifier(input.LoginIdentifier())
+	ip := api.clientIP(r)
+	if err := api.loginThrottler.Check(r.Context(), identifier, ip); err != nil {
+		respondThrottled(w, err)
+		return
+	}
+	
 	// Authenticate the user
-	user, err := api.authService.LoginUser(&input)
+	user, err := api.authService.LoginUser(r.Context(), &input)
 	if err != nil {
+		if errors.Is(err, services.ErrInvalidCredentials) {
+			if err := api.loginThrottler.RecordFailure(r.Context(), identifier, ip); err != nil {
+				requestLogger(r).Error("Error recording failed login", "error", err)
+			}
+		}
 		respondError(w, http.StatusUnauthorized, err.Error())
 		return
 	}
 	
+	if err := api.loginThrottler.Reset(r.Context(), user.ID); err != nil {
+		requestLogger(r).Error("Error resetting login attempts", "error", err)
+	}
+	
 	// Generate JWT token
 	token, err := api.authService.GenerateToken(user)
 	if err != nil {
@@ -64,9 +94,88 @@ func (api *API) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	
-	// Return the token and user info
-	respondJSON(w, http.StatusOK, models.AuthResponse{
+	// Move tasks created as a guest into the account
+	response := models.AuthResponse{
 		Token: token,
 		User:  *user,
-	})
+	}
+	api.claimGuestTasks(w, r, input.ClaimToken, &response)
+	api.startSession(w, &response)
+	
+	// Return the token and user info
+	respondJSON(w, http.StatusOK, response)
+}
+
+// JWKS publishes the public keys that verify our tokens
+func (api *API) JWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	respondJSON(w, http.StatusOK, api.jwtKeys.JWKS())
+}
+
+// respondThrottled sends a 429 response with Retry-After for throttled logins and
+// other rate limited requests
+func respondThrottled(w http.ResponseWriter, err error) {
+	var retryAfter time.Duration
+	var throttled *services.LoginThrottledError
+	var limited *services.RateLimitedError
+	switch {
+	case errors.As(err, &throttled):
+		retryAfter = throttled.RetryAfter
+	case errors.As(err, &limited):
+		retryAfter = limited.RetryAfter
+	default:
+		slog.Error("Error checking rate limit", "error", err)
+		respondError(w, http.StatusInternalServerError, "Failed to process request")
+		return
+	}
+	
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	respondError(w, http.StatusTooManyRequests, err.Error())
+}
+
+// clientIP returns the IP address of the client. Requests from a trusted proxy are
+// attributed to the address it forwarded them for: the last X-Forwarded-For entry
+// that isn't itself a trusted proxy, or X-Real-IP. Those headers are ignored from
+// anyone else, since clients can set them to whatever they like.
+func (api *API) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !api.isTrustedProxy(host) {
+		return host
+	}
+
+	if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+		hops := strings.Split(strings.Join(forwarded, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if net.ParseIP(hop) == nil {
+				break // Can't trust anything before a malformed entry
+			}
+			host = hop
+			if !api.isTrustedProxy(hop) {
+				break
+			}
+		}
+		return host
+	}
+	if realIP := strings.TrimSpace(r.Header.Get("X-Real-IP")); net.ParseIP(realIP) != nil {
+		return realIP
+	}
+	return host
+}
+
+// isTrustedProxy reports whether ip belongs to one of the configured proxies
+func (api *API) isTrustedProxy(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, network := range api.config.TrustedProxies {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
 }
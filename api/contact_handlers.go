@@ -2,30 +2,30 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"io/ioutil"
-	"log"
 	"net/http"
+	"strings"
 
 	"github.com/bongo/golang-learnings/models"
+	"github.com/bongo/golang-learnings/services"
 )
 
 // SubmitContact handles contact form submissions
 func (api *API) SubmitContact(w http.ResponseWriter, r *http.Request) {
-	// Read and log the request body for debugging
+	// Read the request body. It holds personal data, so it is never logged here;
+	// the request logger records a redacted copy when LOG_HTTP_BODIES is on.
 	bodyBytes, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		log.Printf("Error reading request body: %v", err)
+		requestLogger(r).Warn("Error reading request body", "error", err)
 		respondError(w, http.StatusBadRequest, "Invalid request format")
 		return
 	}
 	
-	// Log the raw request for debugging
-	log.Printf("Received contact form submission: %s", string(bodyBytes))
-	
 	// Parse the JSON
 	var input models.ContactInput
 	if err := json.Unmarshal(bodyBytes, &input); err != nil {
-		log.Printf("Error parsing JSON: %v", err)
+		requestLogger(r).Warn("Error parsing JSON", "error", err)
 		respondError(w, http.StatusBadRequest, "Invalid JSON format: " + err.Error())
 		return
 	}
@@ -36,17 +36,51 @@ func (api *API) SubmitContact(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	
+	// Emails to the sender use their language
+	if input.Locale == "" {
+		input.Locale = preferredLanguage(r)
+	}
+	
 	// Submit the contact form
-	contact, err := api.contactService.SubmitContactForm(&input)
-	if err != nil {
-		log.Printf("Error submitting contact form: %v", err)
+	_, err = api.contactService.SubmitContactForm(r.Context(), &input, api.clientIP(r))
+	var limited *services.RateLimitedError
+	switch {
+	case err == nil, errors.Is(err, services.ErrSpamDropped):
+		// Dropped spam gets the same answer so bots can't tell
+	case errors.As(err, &limited):
+		respondThrottled(w, err)
+		return
+	default:
+		requestLogger(r).Error("Error submitting contact form", "error", err)
 		respondError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 	
-	// Return success response
+	// Return success response. The stored message isn't echoed back, it would tell
+	// spammers whether they were flagged.
 	respondJSON(w, http.StatusCreated, map[string]interface{}{
 		"message": "Thank you for your message! We'll get back to you soon.",
-		"contact": contact,
+	})
+}
+
+// preferredLanguage returns the first language of the Accept-Language header, e.g. "de-CH"
+func preferredLanguage(r *http.Request) string {
+	first, _, _ := strings.Cut(r.Header.Get("Accept-Language"), ",")
+	tag, _, _ := strings.Cut(first, ";")
+	tag = strings.TrimSpace(tag)
+	if tag == "*" || len(tag) > 35 {
+		return ""
+	}
+	return tag
+}
+
+// ContactFormToken issues the token the contact form must be submitted with
+func (api *API) ContactFormToken(w http.ResponseWriter, r *http.Request) {
+	token, ttl := api.contactService.FormToken()
+	
+	w.Header().Set("Cache-Control", "no-store")
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"token":      token,
+		"expires_in": int(ttl.Seconds()),
 	})
 }
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"path/filepath"
+	"strconv"
+
+	"github.com/bongo/golang-learnings/services"
+	"github.com/gorilla/mux"
+)
+
+// RequestDataExport starts generating an archive of the user's personal data
+func (api *API) RequestDataExport(w http.ResponseWriter, r *http.Request) {
+	export, err := api.exportService.RequestExport(r.Context(), extractUserID(r))
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to start export")
+		return
+	}
+
+	respondJSON(w, http.StatusAccepted, export)
+}
+
+// GetDataExport returns the status of an export, with a download link once it's ready
+func (api *API) GetDataExport(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid export ID")
+		return
+	}
+
+	export, err := api.exportService.GetExport(r.Context(), id, extractUserID(r))
+	if err != nil {
+		if errors.Is(err, services.ErrExportNotFound) {
+			respondError(w, http.StatusNotFound, "Export not found")
+			return
+		}
+		respondError(w, http.StatusInternalServerError, "Failed to retrieve export")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, export)
+}
+
+// DownloadDataExport serves an export archive through its signed, expiring link
+func (api *API) DownloadDataExport(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid export ID")
+		return
+	}
+
+	query := r.URL.Query()
+	path, err := api.exportService.OpenDownload(r.Context(), id, query.Get("expires"), query.Get("signature"))
+	if err != nil {
+		if errors.Is(err, services.ErrExportNotFound) || errors.Is(err, services.ErrExportExpired) {
+			respondError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		respondError(w, http.StatusInternalServerError, "Failed to retrieve export")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filepath.Base(path)+`"`)
+	http.ServeFile(w, r, path)
+}
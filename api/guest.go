@@ -0,0 +1,106 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/bongo/golang-learnings/models"
+	"github.com/bongo/golang-learnings/services"
+)
+
+// guestCookieName is the cookie carrying an anonymous visitor's signed guest identity
+const guestCookieName = "guest_session"
+
+// guestCookieMaxAge keeps guest task lists around for a month of inactivity
+const guestCookieMaxAge = 30 * 24 * time.Hour
+
+// guestMiddleware gives unauthenticated requests an isolated guest identity,
+// stored in a signed cookie. It must run after optionalAuthMiddleware.
+func (api *API) guestMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if extractUserID(r) != 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		guestID := ""
+		if cookie, err := r.Cookie(guestCookieName); err == nil {
+			if id, err := api.guestService.Verify(cookie.Value); err == nil {
+				guestID = id
+			}
+		}
+
+		// Start a new guest session when there is no valid cookie
+		if guestID == "" {
+			id, token, err := api.guestService.NewGuest()
+			if err != nil {
+				respondError(w, http.StatusInternalServerError, "Failed to create guest session")
+				return
+			}
+			guestID = id
+			http.SetCookie(w, api.guestCookie(token))
+		}
+
+		ctx := context.WithValue(r.Context(), guestIDKey, guestID)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// claimGuestTasks moves the tasks of the request's guest session into the user's
+// account. The guest is identified by an explicit claim token or the guest cookie.
+func (api *API) claimGuestTasks(w http.ResponseWriter, r *http.Request, claimToken string, response *models.AuthResponse) {
+	if claimToken == "" {
+		cookie, err := r.Cookie(guestCookieName)
+		if err != nil {
+			return // Not a guest, nothing to claim
+		}
+		claimToken = cookie.Value
+	}
+	
+	guestID, err := api.guestService.Verify(claimToken)
+	if err != nil {
+		response.ClaimError = "Invalid claim token"
+		return
+	}
+	
+	claimed, err := api.taskService.ClaimGuestTasks(r.Context(), guestID, response.User.ID)
+	if errors.Is(err, services.ErrGuestTasksClaimed) {
+		response.ClaimError = "Guest tasks were already claimed by another account"
+		return
+	}
+	if err != nil {
+		requestLogger(r).Error("Error claiming guest tasks", "error", err)
+		response.ClaimError = "Failed to claim guest tasks"
+		return
+	}
+	
+	// The guest session has been merged into the account
+	response.ClaimedTasks = claimed
+	expired := api.guestCookie("")
+	expired.MaxAge = -1
+	http.SetCookie(w, expired)
+}
+
+// guestCookie builds the cookie that stores a signed guest token
+func (api *API) guestCookie(token string) *http.Cookie {
+	return &http.Cookie{
+		Name:     guestCookieName,
+		Value:    token,
+		Path:     "/api",
+		MaxAge:   int(guestCookieMaxAge.Seconds()),
+		HttpOnly: true,
+		Secure:   api.config.Environment == "production",
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// extractGuestID extracts the guest ID from request context
+func extractGuestID(r *http.Request) string {
+	guestID, ok := r.Context().Value(guestIDKey).(string)
+	if !ok {
+		return "" // Not a guest session
+	}
+	return guestID
+}
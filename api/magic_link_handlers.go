@@ -0,0 +1,82 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/bongo/golang-learnings/models"
+	"github.com/bongo/golang-learnings/services"
+)
+
+// RequestMagicLink emails a sign-in link. The response is the same whether or not
+// the address has an account.
+func (api *API) RequestMagicLink(w http.ResponseWriter, r *http.Request) {
+	var input models.MagicLinkInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	if strings.TrimSpace(input.Email) == "" {
+		respondError(w, http.StatusBadRequest, "Email is required")
+		return
+	}
+
+	if err := api.magicLinks.RequestLink(r.Context(), input.Email); err != nil {
+		var limited *services.RateLimitedError
+		if errors.As(err, &limited) {
+			respondThrottled(w, err)
+			return
+		}
+		requestLogger(r).Error("Error sending sign-in link", "error", err)
+		respondError(w, http.StatusInternalServerError, "Failed to send sign-in link")
+		return
+	}
+
+	respondJSON(w, http.StatusAccepted, map[string]string{
+		"message": "If an account exists for this address, a sign-in link is on its way",
+	})
+}
+
+// MagicLinkCallback exchanges a sign-in link for a login token. Browsers opening the
+// link are sent to the app, which redeems it with a JSON request; this keeps email
+// scanners that merely fetch the link from using it up.
+func (api *API) MagicLinkCallback(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+
+	if strings.Contains(r.Header.Get("Accept"), "text/html") {
+		http.Redirect(w, r, "/#"+url.Values{"magic_link": {token}}.Encode(), http.StatusFound)
+		return
+	}
+
+	user, err := api.magicLinks.ConsumeLink(r.Context(), token)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidMagicLink):
+			respondError(w, http.StatusUnauthorized, err.Error())
+		case errors.Is(err, services.ErrAccountDisabled):
+			respondError(w, http.StatusForbidden, "Account is disabled")
+		default:
+			requestLogger(r).Error("Error redeeming sign-in link", "error", err)
+			respondError(w, http.StatusInternalServerError, "Failed to sign in")
+		}
+		return
+	}
+
+	token, err = api.authService.GenerateToken(user)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to generate token")
+		return
+	}
+
+	response := models.AuthResponse{
+		Token: token,
+		User:  *user,
+	}
+	api.claimGuestTasks(w, r, "", &response)
+	api.startSession(w, &response)
+
+	respondJSON(w, http.StatusOK, response)
+}
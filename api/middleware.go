@@ -2,99 +2,233 @@ package api
 
 import (
 	"context"
-	"log"
+	"errors"
 	"net/http"
 	"strings"
-	"time"
+
+	"github.com/bongo/golang-learnings/logging"
+	"github.com/bongo/golang-learnings/models"
+	"github.com/bongo/golang-learnings/services"
 )
 
-// requestLoggerMiddleware logs all incoming requests
-func requestLoggerMiddleware(next http.Handler) http.Handler {
+// contextKey is the type of the request context keys set by the middleware
+type contextKey string
+
+const (
+	userIDKey     contextKey = "userID"
+	scopesKey     contextKey = "scopes"
+	apiKeyIDKey   contextKey = "apiKeyID"
+	guestIDKey    contextKey = "guestID"
+	requestLogKey contextKey = "requestLog"
+)
+
+// authMiddleware validates JWT tokens or API keys and injects user ID into request context
+func (api *API) authMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		start := time.Now()
+		credential, fromCookie, err := api.credentialFromRequest(r)
+		if err != nil {
+			respondError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+		if credential == "" {
+			respondError(w, http.StatusUnauthorized, "Authentication required")
+			return
+		}
 		
-		// Add request ID
-		requestID := time.Now().Format("20060102150405")
-		ctx := context.WithValue(r.Context(), "requestID", requestID)
-		r = r.WithContext(ctx)
+		// Cookies are sent on cross-site requests too, so they need a CSRF token
+		if fromCookie && !api.validCSRF(r, credential) {
+			respondError(w, http.StatusForbidden, "Invalid or missing CSRF token")
+			return
+		}
 		
-		// Process request
-		next.ServeHTTP(w, r)
+		// Validate token or API key
+		principal, err := api.authenticate(r.Context(), credential)
+		if errors.Is(err, services.ErrAccountDisabled) {
+			respondError(w, http.StatusForbidden, "Account is disabled")
+			return
+		}
+		if err != nil {
+			message := credentialErrorMessage(err)
+			if fromCookie {
+				// The session is no longer valid, drop it
+				api.endSession(w)
+			} else {
+				// Tell bearer clients why the credential was refused (RFC 6750)
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token", error_description="`+message+`"`)
+			}
+			respondError(w, http.StatusUnauthorized, message)
+			return
+		}
 		
-		// Log request details
-		duration := time.Since(start)
-		log.Printf("[%s] %s %s %s - %v", requestID, r.Method, r.URL.Path, r.RemoteAddr, duration)
+		// Continue with the authenticated request
+		next.ServeHTTP(w, withPrincipal(r, principal))
 	})
 }
 
-// authMiddleware validates JWT tokens and injects user ID into request context
-func (api *API) authMiddleware(next http.Handler) http.Handler {
+// credentialErrorMessage describes why a token or API key was rejected
+func credentialErrorMessage(err error) string {
+	switch {
+	case errors.Is(err, services.ErrTokenExpired):
+		return "Token has expired"
+	case errors.Is(err, services.ErrTokenNotYetValid):
+		return "Token is not valid yet"
+	case errors.Is(err, services.ErrTokenSignature):
+		return "Token signature is invalid"
+	case errors.Is(err, services.ErrTokenClaims):
+		return "Token claims are invalid"
+	case errors.Is(err, services.ErrTokenMalformed):
+		return "Token is malformed"
+	case errors.Is(err, services.ErrTokenRevoked):
+		return "Token has been revoked"
+	case errors.Is(err, services.ErrExpiredAPIKey):
+		return "API key has expired"
+	default:
+		return "Invalid or expired credentials"
+	}
+}
+
+// optionalAuthMiddleware validates JWT tokens or API keys if present but doesn't require them
+func (api *API) optionalAuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Get token from Authorization header
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			// No token, continue as unauthenticated
+		credential, fromCookie, err := api.credentialFromRequest(r)
+		if err != nil || credential == "" {
+			// No usable credential, continue as unauthenticated
 			next.ServeHTTP(w, r)
 			return
 		}
 		
-		// Format: "Bearer <token>"
-		parts := strings.Split(authHeader, " ")
-		if len(parts) != 2 || parts[0] != "Bearer" {
-			respondError(w, http.StatusUnauthorized, "Invalid authorization header format")
+		// Don't quietly treat a forged cross-site request as anonymous
+		if fromCookie && !api.validCSRF(r, credential) {
+			respondError(w, http.StatusForbidden, "Invalid or missing CSRF token")
 			return
 		}
 		
-		tokenString := parts[1]
-		
-		// Validate token
-		userID, err := api.authService.ValidateToken(tokenString)
+		// Validate token or API key
+		principal, err := api.authenticate(r.Context(), credential)
 		if err != nil {
-			respondError(w, http.StatusUnauthorized, "Invalid or expired token")
+			// Invalid credential, continue as unauthenticated
+			next.ServeHTTP(w, r)
 			return
 		}
 		
-		// Add user ID to request context
-		ctx := context.WithValue(r.Context(), "userID", userID)
-		
 		// Continue with the authenticated request
-		next.ServeHTTP(w, r.WithContext(ctx))
+		next.ServeHTTP(w, withPrincipal(r, principal))
 	})
 }
 
-// optionalAuthMiddleware validates JWT tokens if present but doesn't require them
-func (api *API) optionalAuthMiddleware(next http.Handler) http.Handler {
+// credentialFromRequest extracts an API key, bearer token or session cookie from the
+// request, reporting whether it came from the cookie. It returns an empty string when
+// the request carries no credential.
+func (api *API) credentialFromRequest(r *http.Request) (string, bool, error) {
+	// Dedicated header for API keys
+	if apiKey := r.Header.Get("X-API-Key"); apiKey != "" {
+		return apiKey, false, nil
+	}
+	
+	if authHeader := r.Header.Get("Authorization"); authHeader != "" {
+		// Format: "Bearer <token or API key>"
+		parts := strings.Split(authHeader, " ")
+		if len(parts) != 2 || parts[0] != "Bearer" {
+			return "", false, errors.New("Invalid authorization header format")
+		}
+		if !services.IsAPIKey(parts[1]) && !api.bearerTokens() {
+			return "", false, errors.New("Bearer tokens are not accepted, log in to get a session cookie")
+		}
+		return parts[1], false, nil
+	}
+	
+	if api.cookieSessions() {
+		if cookie, err := r.Cookie(sessionCookieName); err == nil && cookie.Value != "" {
+			return cookie.Value, true, nil
+		}
+	}
+	
+	return "", false, nil
+}
+
+// authenticate resolves the principal for a credential, which is either a JWT or an API key
+func (api *API) authenticate(ctx context.Context, credential string) (*services.Principal, error) {
+	var principal *services.Principal
+	if services.IsAPIKey(credential) {
+		key, err := api.apiKeyService.ValidateAPIKey(ctx, credential)
+		if err != nil {
+			return nil, err
+		}
+		principal = &services.Principal{
+			UserID:   key.UserID,
+			Scopes:   services.KeyScopes(key),
+			APIKeyID: key.ID,
+		}
+	} else {
+		var err error
+		principal, err = api.authService.ValidateToken(credential)
+		if err != nil {
+			return nil, err
+		}
+	}
+	
+	// Reject disabled accounts and drop admin rights that were revoked
+	return api.authService.ResolvePrincipal(ctx, principal)
+}
+
+// withPrincipal adds the authenticated user ID and scopes to the request context and
+// its logger
+func withPrincipal(r *http.Request, principal *services.Principal) *http.Request {
+	ctx := context.WithValue(r.Context(), userIDKey, principal.UserID)
+	ctx = context.WithValue(ctx, scopesKey, principal.Scopes)
+	if principal.APIKeyID != 0 {
+		ctx = context.WithValue(ctx, apiKeyIDKey, principal.APIKeyID)
+	}
+	
+	// Identify the user in the request's log entries
+	ctx = logging.NewContext(ctx, logging.FromContext(ctx).With("user_id", principal.UserID))
+	if entry, ok := ctx.Value(requestLogKey).(*requestLog); ok {
+		entry.userID = principal.UserID
+	}
+	return r.WithContext(ctx)
+}
+
+// adminMiddleware only lets through requests from admins. It must run after authMiddleware.
+func (api *API) adminMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Get token from Authorization header
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			// No token, continue as unauthenticated
-			next.ServeHTTP(w, r)
+		if extractUserID(r) == 0 {
+			respondError(w, http.StatusUnauthorized, "Authentication required")
 			return
 		}
 		
-		// Format: "Bearer <token>"
-		parts := strings.Split(authHeader, " ")
-		if len(parts) != 2 || parts[0] != "Bearer" {
-			// Invalid format, continue as unauthenticated
-			next.ServeHTTP(w, r)
+		// Only admins are granted the admin scope, see AuthService.ResolvePrincipal
+		if !models.HasScope(extractScopes(r), models.ScopeAdmin) {
+			respondError(w, http.StatusForbidden, "Admin access required")
 			return
 		}
 		
-		tokenString := parts[1]
-		
-		// Validate token
-		userID, err := api.authService.ValidateToken(tokenString)
-		if err != nil {
-			// Invalid token, continue as unauthenticated
-			next.ServeHTTP(w, r)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// sessionMiddleware rejects requests authenticated with an API key. Account
+// settings, API keys and data exports need a login, so a leaked key can't be used
+// to take over the account. It must run after authMiddleware.
+func (api *API) sessionMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.Context().Value(apiKeyIDKey).(int64); ok {
+			respondError(w, http.StatusForbidden, "API keys can't be used to manage the account")
 			return
 		}
 		
-		// Add user ID to request context
-		ctx := context.WithValue(r.Context(), "userID", userID)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireScope rejects authenticated requests whose credential lacks the given scope.
+// Anonymous requests are left to the auth middleware guarding the route.
+func requireScope(scope string, next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if extractUserID(r) != 0 && !models.HasScope(extractScopes(r), scope) {
+			respondError(w, http.StatusForbidden, "Missing required scope: "+scope)
+			return
+		}
 		
-		// Continue with the authenticated request
-		next.ServeHTTP(w, r.WithContext(ctx))
+		next.ServeHTTP(w, r)
 	})
 }
@@ -0,0 +1,117 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+
+	"github.com/bongo/golang-learnings/models"
+	"github.com/bongo/golang-learnings/services"
+)
+
+// oidcFlowCookieName carries the signed state of a login in progress at the identity provider
+const oidcFlowCookieName = "oidc_flow"
+
+// ListAuthProviders lists the external login options, empty when single sign-on is off
+func (api *API) ListAuthProviders(w http.ResponseWriter, r *http.Request) {
+	providers := []models.AuthProvider{}
+	if api.oidcService != nil {
+		providers = append(providers, models.AuthProvider{
+			ID:       "oidc",
+			Name:     api.config.OIDCProviderName,
+			LoginURL: "/api/auth/oidc/login",
+		})
+	}
+	
+	respondJSON(w, http.StatusOK, providers)
+}
+
+// OIDCLogin redirects the browser to the identity provider
+func (api *API) OIDCLogin(w http.ResponseWriter, r *http.Request) {
+	authURL, flow, err := api.oidcService.StartLogin(r.Context())
+	if err != nil {
+		requestLogger(r).Error("Error starting OIDC login", "error", err)
+		respondError(w, http.StatusBadGateway, "Identity provider is unavailable")
+		return
+	}
+	
+	http.SetCookie(w, api.oidcFlowCookie(flow, int(services.OIDCFlowTTL.Seconds())))
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// OIDCCallback completes a login at the identity provider and hands the token to
+// the frontend in the URL fragment, which never reaches server logs, or sets the
+// session cookie when bearer tokens are disabled
+func (api *API) OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	// The login state is single use
+	http.SetCookie(w, api.oidcFlowCookie("", -1))
+	
+	query := r.URL.Query()
+	if providerError := query.Get("error"); providerError != "" {
+		requestLogger(r).Warn("OIDC login failed at provider", "error", providerError, "description", query.Get("error_description"))
+		redirectSSOError(w, r, "Login was cancelled or denied")
+		return
+	}
+	
+	cookie, err := r.Cookie(oidcFlowCookieName)
+	if err != nil {
+		redirectSSOError(w, r, services.ErrInvalidOIDCState.Error())
+		return
+	}
+	
+	user, err := api.oidcService.CompleteLogin(r.Context(), query.Get("code"), query.Get("state"), cookie.Value)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidOIDCState),
+			errors.Is(err, services.ErrOIDCEmailMissing),
+			errors.Is(err, services.ErrOIDCAccountExists),
+			errors.Is(err, services.ErrOIDCEmailUnverified),
+			errors.Is(err, services.ErrAccountDisabled):
+			redirectSSOError(w, r, err.Error())
+		default:
+			requestLogger(r).Error("Error completing OIDC login", "error", err)
+			redirectSSOError(w, r, "Single sign-on failed")
+		}
+		return
+	}
+	
+	token, err := api.authService.GenerateToken(user)
+	if err != nil {
+		redirectSSOError(w, r, "Failed to generate token")
+		return
+	}
+	
+	// Move tasks created as a guest into the account
+	response := models.AuthResponse{
+		Token: token,
+		User:  *user,
+	}
+	api.claimGuestTasks(w, r, "", &response)
+	api.startSession(w, &response)
+	
+	// With cookie sessions the frontend only needs to know the login succeeded
+	fragment := url.Values{"sso_session": {"1"}}
+	if response.Token != "" {
+		fragment = url.Values{"sso_token": {response.Token}}
+	}
+	http.Redirect(w, r, "/#"+fragment.Encode(), http.StatusFound)
+}
+
+// oidcFlowCookie builds the cookie holding the login state. It must be sent on
+// the cross-site redirect back from the provider, so SameSite is Lax.
+func (api *API) oidcFlowCookie(value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     oidcFlowCookieName,
+		Value:    value,
+		Path:     "/api/auth/oidc",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   api.config.Environment == "production",
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// redirectSSOError sends the browser back to the app with an error to display
+func redirectSSOError(w http.ResponseWriter, r *http.Request, message string) {
+	http.Redirect(w, r, "/#"+url.Values{"sso_error": {message}}.Encode(), http.StatusFound)
+}
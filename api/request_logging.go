@@ -0,0 +1,116 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+
+	"github.com/bongo/golang-learnings/logging"
+)
+
+// requestIDHeader carries the request ID in requests from proxies and in responses
+const requestIDHeader = "X-Request-ID"
+
+// maxCapturedBody is how much of a body is kept for the request log. Bodies must be
+// complete to be parsed and redacted field by field, so longer ones are not logged.
+const maxCapturedBody = 64 << 10
+
+// isLoggableContent reports whether bodies of the content type are worth logging.
+// Static files, downloads and exports are left out.
+func isLoggableContent(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || mediaType == "application/x-www-form-urlencoded"
+}
+
+// cappedBuffer keeps the first maxCapturedBody bytes written to it and counts the rest
+type cappedBuffer struct {
+	buf   bytes.Buffer
+	total int
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	b.total += len(p)
+	if room := maxCapturedBody - b.buf.Len(); room > 0 {
+		if len(p) > room {
+			b.buf.Write(p[:room])
+		} else {
+			b.buf.Write(p)
+		}
+	}
+	return len(p), nil
+}
+
+// Len is the number of bytes written, including those that weren't kept
+func (b *cappedBuffer) Len() int {
+	return b.total
+}
+
+// Redacted formats the body for the log
+func (b *cappedBuffer) Redacted(redactor *logging.Redactor) string {
+	if b.total > b.buf.Len() {
+		return fmt.Sprintf("(%d bytes, too large to log)", b.total)
+	}
+	return redactor.Body(b.buf.Bytes())
+}
+
+// readCloser pairs a reader with the Close of the body it wraps
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// responseRecorder passes a response through, noting its status and size
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	written     int
+	wroteHeader bool
+	body        *cappedBuffer // nil unless bodies are logged
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(p []byte) (int, error) {
+	r.wroteHeader = true
+	n, err := r.ResponseWriter.Write(p)
+	r.written += n
+	if r.body != nil {
+		r.body.Write(p[:n])
+	}
+	return n, err
+}
+
+// Flush lets streamed responses through
+func (r *responseRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap gives http.ResponseController access to the underlying writer
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// requestLog collects what handlers learn about a request for its log entry
+type requestLog struct {
+	userID int64
+}
+
+// requestLogger returns the logger of the request, which carries its request ID and,
+// once authenticated, the user ID
+func requestLogger(r *http.Request) *slog.Logger {
+	return logging.FromContext(r.Context())
+}
@@ -1,12 +1,19 @@
 package api
 
 import (
-	"log"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/bongo/golang-learnings/config"
 	"github.com/bongo/golang-learnings/db"
+	"github.com/bongo/golang-learnings/logging"
+	"github.com/bongo/golang-learnings/models"
 	"github.com/bongo/golang-learnings/services"
 	"github.com/gorilla/mux"
 )
@@ -17,86 +24,374 @@ type Server struct {
 	router   *mux.Router
 	taskRepo *db.TaskRepository
 	config   *config.Config
+	notifier *services.Notifier
+	exports  *services.DataExportService
 }
 
 // NewServer creates and configures a new HTTP server
-func NewServer(cfg *config.Config, taskRepo *db.TaskRepository, userRepo *db.UserRepository, contactRepo *db.ContactRepository) *http.Server {
+func NewServer(cfg *config.Config, taskRepo *db.TaskRepository, userRepo *db.UserRepository, contactRepo *db.ContactRepository) (*Server, error) {
 	router := mux.NewRouter()
 	
+	passwordPolicy, err := newPasswordPolicy(cfg)
+	if err != nil {
+		return nil, err
+	}
+	
+	jwtKeys, err := newJWTKeySet(cfg)
+	if err != nil {
+		return nil, err
+	}
+	
 	// Create services
 	taskService := services.NewTaskService(taskRepo.DB)
-	authService := services.NewAuthService(userRepo.DB, cfg.JWTSecret)
-	contactService := services.NewContactService(contactRepo.DB)
+	authService := services.NewAuthService(userRepo.DB, jwtKeys)
+	authService.SetPasswordPolicy(passwordPolicy)
+	authService.SetTokenOptions(services.TokenOptions{
+		Issuer:   cfg.JWTIssuer,
+		Audience: cfg.JWTAudience,
+		TTL:      cfg.JWTTTL,
+		Leeway:   cfg.JWTLeeway,
+	})
+	apiKeyService := services.NewAPIKeyService(userRepo.DB)
+	adminService := services.NewAdminService(userRepo.DB)
+	mailer := newMailer(cfg)
+	emailTemplates, err := services.LoadEmailTemplates(cfg.EmailTemplatesDir, cfg.EmailDefaultLocale)
+	if err != nil {
+		return nil, err
+	}
+	notifier := newNotifier(cfg, mailer)
+	userService := services.NewUserService(userRepo.DB, authService, mailer, emailTemplates, cfg.BaseURL)
+	magicLinkService := services.NewMagicLinkService(userRepo.DB, mailer, emailTemplates, notifier, cfg.BaseURL, cfg.MagicLinkTTL,
+		services.NewRateLimiter(cfg.MagicLinkLimit, cfg.MagicLinkWindow))
+	exportService := services.NewDataExportService(userRepo.DB, cfg.ExportDir, cfg.ExportLinkTTL, cfg.JWTSecret, cfg.BaseURL)
+	if err := exportService.FailInterrupted(context.Background()); err != nil {
+		return nil, fmt.Errorf("failed to clean up data exports: %w", err)
+	}
+	guestService := services.NewGuestSessionService(cfg.GuestSecret)
+	loginThrottler := newLoginThrottler(cfg, userRepo, authService)
+	contactSpamGuard := services.NewContactSpamGuard(cfg.JWTSecret, cfg.ContactMinFillTime, cfg.ContactTokenTTL,
+		services.NewRateLimiter(cfg.ContactLimitPerIP, cfg.ContactLimitWindow),
+		services.NewRateLimiter(cfg.ContactLimitPerEmail, cfg.ContactLimitWindow),
+		services.NewHeuristicClassifier(cfg.ContactMaxLinks, cfg.ContactSpamKeywords))
+	contactService := services.NewContactService(contactRepo.DB, mailer, emailTemplates, contactSpamGuard, notifier)
+	contactService.SetAcknowledgements(cfg.Features.Enabled(config.FeatureContactAcknowledgement))
 	
 	// Create API handler
 	api := &API{
 		taskService:    taskService,
 		authService:    authService,
+		apiKeyService:  apiKeyService,
+		adminService:   adminService,
+		userService:    userService,
+		magicLinks:     magicLinkService,
+		exportService:  exportService,
+		guestService:   guestService,
+		csrfService:    services.NewCSRFService(cfg.JWTSecret),
+		loginThrottler: loginThrottler,
 		contactService: contactService,
+		jwtKeys:        jwtKeys,
 		config:         cfg,
 	}
+	if cfg.OIDCIssuer != "" {
+		api.oidcService = services.NewOIDCService(userRepo.DB, services.OIDCConfig{
+			Issuer:       cfg.OIDCIssuer,
+			ClientID:     cfg.OIDCClientID,
+			ClientSecret: cfg.OIDCClientSecret,
+			RedirectURL:  cfg.OIDCRedirectURL,
+			Scopes:       strings.Fields(cfg.OIDCScopes),
+		}, cfg.JWTSecret, &http.Client{Timeout: 10 * time.Second})
+	}
+	
+	// Bootstrap the first admin account if configured
+	if cfg.AdminEmail != "" {
+		admin, err := authService.BootstrapAdmin(context.Background(), cfg.AdminUsername, cfg.AdminEmail, cfg.AdminPassword)
+		if err != nil {
+			return nil, fmt.Errorf("failed to bootstrap admin account: %w", err)
+		}
+		if admin != nil {
+			slog.Info("Admin account ready", "user_id", admin.ID)
+		}
+	}
 	
 	// Set up routes
 	
+	// Public keys for verifying our tokens
+	router.HandleFunc("/.well-known/jwks.json", api.JWKS).Methods("GET")
+	
 	// Public API routes
 	apiRouter := router.PathPrefix("/api").Subrouter()
 	apiRouter.HandleFunc("/health", api.HealthCheck).Methods("GET")
+	apiRouter.HandleFunc("/features", api.ListFeatures).Methods("GET")
 	
 	// Auth routes - no auth required
 	authRouter := apiRouter.PathPrefix("/auth").Subrouter()
 	authRouter.HandleFunc("/register", api.Register).Methods("POST")
 	authRouter.HandleFunc("/login", api.Login).Methods("POST")
+	authRouter.HandleFunc("/logout", api.Logout).Methods("POST")
+	authRouter.HandleFunc("/providers", api.ListAuthProviders).Methods("GET")
+	if cfg.Features.Enabled(config.FeatureMagicLinks) {
+		authRouter.HandleFunc("/magic-link", api.RequestMagicLink).Methods("POST")
+		authRouter.HandleFunc("/magic-link/callback", api.MagicLinkCallback).Methods("GET")
+	}
+	
+	// Single sign-on through the configured OpenID Connect provider
+	if api.oidcService != nil {
+		authRouter.HandleFunc("/oidc/login", api.OIDCLogin).Methods("GET")
+		authRouter.HandleFunc("/oidc/callback", api.OIDCCallback).Methods("GET")
+	}
+	
+	// API key management - a login required, API keys can't manage keys
+	apiKeyRouter := authRouter.PathPrefix("/api-keys").Subrouter()
+	apiKeyRouter.Use(api.authMiddleware, api.sessionMiddleware)
+	
+	apiKeyRouter.HandleFunc("", api.ListAPIKeys).Methods("GET")
+	apiKeyRouter.HandleFunc("", api.CreateAPIKey).Methods("POST")
+	apiKeyRouter.HandleFunc("/{id:[0-9]+}", api.RevokeAPIKey).Methods("DELETE")
 	
-	// Task routes - with optional authentication
+	// Task routes - authentication required, or a guest session for anonymous visitors
 	taskRouter := apiRouter.PathPrefix("/tasks").Subrouter()
-	taskRouter.Use(api.optionalAuthMiddleware)
+	if cfg.TaskAuthMode == config.TaskAuthRequired {
+		taskRouter.Use(api.authMiddleware)
+	} else {
+		taskRouter.Use(api.optionalAuthMiddleware, api.guestMiddleware)
+	}
+	
+	taskRouter.Handle("", requireScope(models.ScopeTasksRead, api.GetTasks)).Methods("GET")
+	taskRouter.Handle("", requireScope(models.ScopeTasksWrite, api.CreateTask)).Methods("POST")
+	taskRouter.Handle("/{id:[0-9]+}", requireScope(models.ScopeTasksRead, api.GetTask)).Methods("GET")
+	taskRouter.Handle("/{id:[0-9]+}", requireScope(models.ScopeTasksWrite, api.UpdateTask)).Methods("PUT")
+	taskRouter.Handle("/{id:[0-9]+}", requireScope(models.ScopeTasksWrite, api.DeleteTask)).Methods("DELETE")
+	
+	// Account routes - a login required, except for the link in verification emails
+	apiRouter.HandleFunc("/users/verify-email", api.VerifyEmail).Methods("GET")
+	
+	userRouter := apiRouter.PathPrefix("/users/me").Subrouter()
+	userRouter.Use(api.authMiddleware, api.sessionMiddleware)
+	
+	userRouter.HandleFunc("", api.GetProfile).Methods("GET")
+	userRouter.HandleFunc("", api.UpdateProfile).Methods("PATCH")
+	userRouter.HandleFunc("", api.DeleteAccount).Methods("DELETE")
+	userRouter.HandleFunc("/password", api.ChangePassword).Methods("POST")
+	if cfg.Features.Enabled(config.FeatureDataExport) {
+		userRouter.HandleFunc("/export", api.RequestDataExport).Methods("POST")
+		userRouter.HandleFunc("/export/{id:[0-9]+}", api.GetDataExport).Methods("GET")
+		
+		// Export downloads are authorized by their signed, expiring link
+		apiRouter.HandleFunc("/exports/{id:[0-9]+}/download", api.DownloadDataExport).Methods("GET")
+	}
+	
+	// Admin routes - admin role required. Reading the contact inbox only needs the
+	// contacts:read scope, which only admins can hold.
+	adminRouter := apiRouter.PathPrefix("/admin").Subrouter()
+	adminRouter.Use(api.authMiddleware)
 	
-	taskRouter.HandleFunc("", api.GetTasks).Methods("GET")
-	taskRouter.HandleFunc("", api.CreateTask).Methods("POST")
-	taskRouter.HandleFunc("/{id:[0-9]+}", api.GetTask).Methods("GET")
-	taskRouter.HandleFunc("/{id:[0-9]+}", api.UpdateTask).Methods("PUT")
-	taskRouter.HandleFunc("/{id:[0-9]+}", api.DeleteTask).Methods("DELETE")
+	adminRouter.Handle("/contacts", requireScope(models.ScopeContactsRead, api.ListContacts)).Methods("GET")
+	adminRouter.Handle("/contacts/{id:[0-9]+}", requireScope(models.ScopeContactsRead, api.GetContact)).Methods("GET")
 	
-	// Contact form submission
-	apiRouter.HandleFunc("/contact", api.SubmitContact).Methods("POST")
+	adminRouter = adminRouter.NewRoute().Subrouter()
+	adminRouter.Use(api.adminMiddleware)
+	
+	adminRouter.HandleFunc("/users", api.ListUsers).Methods("GET")
+	adminRouter.HandleFunc("/users/{id:[0-9]+}/disable", api.DisableUser).Methods("POST")
+	adminRouter.HandleFunc("/users/{id:[0-9]+}/enable", api.EnableUser).Methods("POST")
+	adminRouter.HandleFunc("/users/{id:[0-9]+}/reset-password", api.ResetUserPassword).Methods("POST")
+	adminRouter.HandleFunc("/contacts/{id:[0-9]+}", api.UpdateContact).Methods("PATCH")
+	adminRouter.HandleFunc("/contacts/{id:[0-9]+}", api.DeleteContact).Methods("DELETE")
+	adminRouter.HandleFunc("/contacts/{id:[0-9]+}/notes", api.AddContactNote).Methods("POST")
+	adminRouter.HandleFunc("/contacts/{id:[0-9]+}/reply", api.ReplyToContact).Methods("POST")
+	adminRouter.HandleFunc("/contacts/{id:[0-9]+}/read", api.MarkContactRead).Methods("POST")
+	adminRouter.HandleFunc("/contacts/{id:[0-9]+}/unread", api.MarkContactUnread).Methods("POST")
+	
+	// Contact form submission. The admin inbox stays available for earlier messages.
+	if cfg.Features.Enabled(config.FeatureContactForm) {
+		apiRouter.HandleFunc("/contact", api.SubmitContact).Methods("POST")
+		apiRouter.HandleFunc("/contact/token", api.ContactFormToken).Methods("GET")
+	}
 	
 	// Serve static files
 	router.PathPrefix("/").Handler(http.FileServer(http.Dir(cfg.StaticDir)))
 	
 	// Apply global middleware
-	handler := logRequestMiddleware(router)
+	redactor := logging.NewRedactor(cfg.LogRedact, cfg.LogRedactFields)
+	handler := logRequestMiddleware(redactor, cfg.LogHTTPBodies, router)
 	
 	// Create and configure the server
-	server := &http.Server{
-		Addr:         ":" + cfg.Port,
-		Handler:      handler,
-		ReadTimeout:  15 * time.Second,
-		WriteTimeout: 15 * time.Second,
-		IdleTimeout:  60 * time.Second,
+	server := &Server{
+		Server: &http.Server{
+			Addr:         ":" + cfg.Port,
+			Handler:      handler,
+			ReadTimeout:  15 * time.Second,
+			WriteTimeout: 15 * time.Second,
+			IdleTimeout:  60 * time.Second,
+		},
+		router:   router,
+		taskRepo: taskRepo,
+		config:   cfg,
+		notifier: notifier,
+		exports:  exportService,
 	}
 	
-	return server
+	return server, nil
+}
+
+// Shutdown stops the HTTP server gracefully, then waits for data exports to be
+// generated and queued notifications to be delivered. Each step runs even if an
+// earlier one fails, and their errors are returned together.
+func (s *Server) Shutdown(ctx context.Context) error {
+	return errors.Join(
+		s.Server.Shutdown(ctx),
+		s.exports.Wait(ctx),
+		s.notifier.Shutdown(ctx),
+	)
 }
 
-// logRequestMiddleware logs all incoming requests
-func logRequestMiddleware(next http.Handler) http.Handler {
+// newJWTKeySet creates the token signing keys for the configured algorithm
+func newJWTKeySet(cfg *config.Config) (*services.JWTKeySet, error) {
+	if cfg.JWTAlgorithm == config.JWTAlgorithmHS256 {
+		return services.NewHMACKeySet(cfg.JWTSecret), nil
+	}
+	
+	keys, err := services.LoadJWTKeySet(cfg.JWTAlgorithm, cfg.JWTPrivateKeyFile, cfg.JWTKeyID, cfg.JWTVerificationKeys)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load JWT keys: %w", err)
+	}
+	return keys, nil
+}
+
+// newPasswordPolicy creates the password policy, loading the breached password list if configured
+func newPasswordPolicy(cfg *config.Config) (*services.PasswordPolicy, error) {
+	policy := &services.PasswordPolicy{
+		MinLength:        cfg.PasswordMinLength,
+		RequireUpper:     cfg.PasswordRequireUpper,
+		RequireLower:     cfg.PasswordRequireLower,
+		RequireDigit:     cfg.PasswordRequireDigit,
+		RequireSymbol:    cfg.PasswordRequireSymbol,
+		DisallowIdentity: cfg.PasswordDisallowIdentity,
+	}
+	
+	if cfg.BreachedPasswordsFile != "" {
+		breached, err := services.LoadBreachedPasswordList(cfg.BreachedPasswordsFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load breached passwords: %w", err)
+		}
+		policy.Breached = breached
+	}
+	
+	return policy, nil
+}
+
+// newMailer creates an SMTP mailer, or a mailer that logs emails when SMTP isn't
+// configured, which the config only allows outside production
+func newMailer(cfg *config.Config) services.Mailer {
+	if cfg.SMTPHost == "" {
+		return services.NewLogMailer(logging.NewRedactor(cfg.LogRedact, cfg.LogRedactFields))
+	}
+	return services.NewSMTPMailer(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword, cfg.MailFrom)
+}
+
+// newNotifier creates the notifier with a channel for each configured destination
+func newNotifier(cfg *config.Config, mailer services.Mailer) *services.Notifier {
+	var channels []services.NotificationChannel
+	if len(cfg.NotifyEmails) > 0 {
+		channels = append(channels, services.NewEmailChannel(mailer, cfg.NotifyEmails))
+	}
+	if cfg.NotifyWebhookURL != "" {
+		channels = append(channels, services.NewWebhookChannel(cfg.NotifyWebhookURL, cfg.NotifyWebhookSecret,
+			&http.Client{Timeout: 10 * time.Second}))
+	}
+	
+	return services.NewNotifier(channels, cfg.NotifyWorkers, cfg.NotifyQueueSize, cfg.NotifyMaxAttempts, cfg.NotifyRetryBackoff)
+}
+
+// newLoginThrottler creates the login throttler with the configured attempt store
+func newLoginThrottler(cfg *config.Config, userRepo *db.UserRepository, authService *services.AuthService) *services.LoginThrottler {
+	var store services.LoginAttemptStore
+	if cfg.LoginThrottleStore == "memory" {
+		store = services.NewMemoryLoginAttemptStore()
+	} else {
+		store = services.NewDBLoginAttemptStore(userRepo.DB)
+	}
+
+	accountPolicy := services.LoginThrottlePolicy{
+		MaxFailures:     cfg.LoginMaxFailures,
+		BaseDelay:       cfg.LoginBackoffBase,
+		MaxDelay:        cfg.LoginBackoffMax,
+		LockoutDuration: cfg.LoginLockoutDuration,
+	}
+	ipPolicy := accountPolicy
+	ipPolicy.MaxFailures = cfg.LoginMaxFailuresPerIP
+
+	return services.NewLoginThrottler(store, authService.LookupUserID, accountPolicy, ipPolicy)
+}
+
+// logRequestMiddleware logs every request once it has completed. Each request gets an
+// ID, returned in the X-Request-ID header, and a logger carrying that ID. Query
+// parameters are redacted before they are logged, and so are the headers and bodies
+// that are logged when logBodies is set.
+func logRequestMiddleware(redactor *logging.Redactor, logBodies bool, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
-		
-		// Add request ID header
-		requestID := time.Now().Format("20060102150405")
-		w.Header().Set("X-Request-ID", requestID)
-		
+
+		// Keep the request ID of a proxy in front of us so logs can be correlated,
+		// otherwise start a new one
+		requestID := r.Header.Get(requestIDHeader)
+		if !logging.ValidRequestID(requestID) {
+			requestID = logging.NewRequestID()
+		}
+		w.Header().Set(requestIDHeader, requestID)
+
+		// Handlers and services log through the request's logger; withPrincipal adds the user ID
+		entry := &requestLog{}
+		logger := slog.Default().With("request_id", requestID)
+		ctx := logging.WithRequestID(r.Context(), requestID)
+		ctx = logging.NewContext(ctx, logger)
+		ctx = context.WithValue(ctx, requestLogKey, entry)
+		r = r.WithContext(ctx)
+
+		// Record what the handler reads and writes
+		recorder := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		var requestBody *cappedBuffer
+		if logBodies && r.Body != nil && isLoggableContent(r.Header.Get("Content-Type")) {
+			requestBody = &cappedBuffer{}
+			r.Body = readCloser{Reader: io.TeeReader(r.Body, requestBody), Closer: r.Body}
+		}
+		if logBodies {
+			recorder.body = &cappedBuffer{}
+		}
+
 		// Process request
-		next.ServeHTTP(w, r)
-		
+		next.ServeHTTP(recorder, r)
+
 		// Log request details
-		duration := time.Since(start)
-		log.Printf("[%s] %s %s %s - Completed in %v", 
-			requestID, 
-			r.Method, 
-			r.URL.Path, 
-			r.RemoteAddr, 
-			duration)
+		attrs := []any{
+			"method", r.Method,
+			"path", r.URL.Path,
+			"remote_addr", r.RemoteAddr,
+			"status", recorder.status,
+			"bytes", recorder.written,
+			"duration", time.Since(start),
+		}
+		if r.URL.RawQuery != "" {
+			attrs = append(attrs, "query", redactor.Query(r.URL.Query()))
+		}
+		if entry.userID != 0 {
+			attrs = append(attrs, "user_id", entry.userID)
+		}
+		if logBodies {
+			attrs = append(attrs, "request_headers", redactor.Headers(r.Header))
+			if requestBody != nil && requestBody.Len() > 0 {
+				attrs = append(attrs, "request_body", requestBody.Redacted(redactor))
+			}
+			attrs = append(attrs, "response_headers", redactor.Headers(recorder.Header()))
+			if isLoggableContent(recorder.Header().Get("Content-Type")) && recorder.body.Len() > 0 {
+				attrs = append(attrs, "response_body", recorder.body.Redacted(redactor))
+			}
+		}
+
+		level := slog.LevelInfo
+		if recorder.status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		logger.Log(r.Context(), level, "Request completed", attrs...)
 	})
 }
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/bongo/golang-learnings/config"
+	"github.com/bongo/golang-learnings/models"
+)
+
+// Cookies used when sessions are kept in cookies (AUTH_MODE cookie or both)
+const (
+	sessionCookieName = "session"    // HttpOnly, carries the login token
+	csrfCookieName    = "csrf_token" // Readable by the frontend, echoed in the X-CSRF-Token header
+	csrfHeaderName    = "X-CSRF-Token"
+)
+
+// cookieSessions reports whether logins set a session cookie
+func (api *API) cookieSessions() bool {
+	return api.config.AuthMode == config.AuthModeCookie || api.config.AuthMode == config.AuthModeBoth
+}
+
+// bearerTokens reports whether login tokens are handed to clients and accepted in the Authorization header
+func (api *API) bearerTokens() bool {
+	return api.config.AuthMode == config.AuthModeBearer || api.config.AuthMode == config.AuthModeBoth
+}
+
+// startSession stores the response's token in the session cookie when cookie
+// sessions are enabled, and removes it from the body when bearer tokens are not
+func (api *API) startSession(w http.ResponseWriter, response *models.AuthResponse) {
+	if api.cookieSessions() {
+		csrfToken := api.csrfService.Token(response.Token)
+		http.SetCookie(w, api.sessionCookie(sessionCookieName, response.Token, true))
+		http.SetCookie(w, api.sessionCookie(csrfCookieName, csrfToken, false))
+		response.CSRFToken = csrfToken
+	}
+	if !api.bearerTokens() {
+		response.Token = ""
+	}
+}
+
+// endSession clears the session cookies
+func (api *API) endSession(w http.ResponseWriter) {
+	for _, name := range []string{sessionCookieName, csrfCookieName} {
+		cookie := api.sessionCookie(name, "", name == sessionCookieName)
+		cookie.MaxAge = -1
+		http.SetCookie(w, cookie)
+	}
+}
+
+// sessionCookie builds a session cookie that lives as long as the login token
+func (api *API) sessionCookie(name, value string, httpOnly bool) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   int(api.config.JWTTTL.Seconds()),
+		HttpOnly: httpOnly,
+		Secure:   api.config.Environment == "production",
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// validCSRF checks the CSRF token of a request authenticated by the session cookie.
+// Safe methods don't change state and need no token.
+func (api *API) validCSRF(r *http.Request, session string) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return api.csrfService.Verify(session, r.Header.Get(csrfHeaderName))
+}
+
+// Logout ends a cookie session. Bearer token clients simply discard their token.
+func (api *API) Logout(w http.ResponseWriter, r *http.Request) {
+	api.endSession(w)
+	respondJSON(w, http.StatusNoContent, nil)
+}
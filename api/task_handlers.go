@@ -2,7 +2,8 @@ package api
 
 import (
 	"encoding/json"
-	"log"
+	"errors"
+	"log/slog"
 	"net/http"
 	"strconv"
 	"time"
@@ -17,7 +18,17 @@ import (
 type API struct {
 	taskService    *services.TaskService
 	authService    *services.AuthService
+	apiKeyService  *services.APIKeyService
+	guestService   *services.GuestSessionService
+	csrfService    *services.CSRFService
+	loginThrottler *services.LoginThrottler
+	adminService   *services.AdminService
+	userService    *services.UserService
+	magicLinks     *services.MagicLinkService
+	exportService  *services.DataExportService
 	contactService *services.ContactService
+	oidcService    *services.OIDCService // nil when single sign-on is not configured
+	jwtKeys        *services.JWTKeySet
 	config         *config.Config
 }
 
@@ -30,12 +41,21 @@ func (api *API) HealthCheck(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// ListFeatures reports which optional features are enabled, so the frontend can hide the others
+func (api *API) ListFeatures(w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, http.StatusOK, api.config.Features)
+}
+
 // GetTasks returns all tasks for the authenticated user
 func (api *API) GetTasks(w http.ResponseWriter, r *http.Request) {
-	// Get user ID from context (set by auth middleware)
-	userID := extractUserID(r)
+	// Get the task owner from context (set by auth or guest middleware)
+	owner := extractTaskOwner(r)
 	
-	tasks, err := api.taskService.GetAllTasks(userID)
+	tasks, err := api.taskService.GetAllTasks(r.Context(), owner)
+	if errors.Is(err, services.ErrNoTaskOwner) {
+		respondError(w, http.StatusUnauthorized, "Authentication required")
+		return
+	}
 	if err != nil {
 		respondError(w, http.StatusInternalServerError, "Failed to retrieve tasks")
 		return
@@ -52,8 +72,12 @@ func (api *API) GetTask(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	
-	userID := extractUserID(r)
-	task, err := api.taskService.GetTaskByID(id, userID)
+	owner := extractTaskOwner(r)
+	task, err := api.taskService.GetTaskByID(r.Context(), id, owner)
+	if errors.Is(err, services.ErrNoTaskOwner) {
+		respondError(w, http.StatusUnauthorized, "Authentication required")
+		return
+	}
 	if err != nil {
 		respondError(w, http.StatusInternalServerError, "Failed to retrieve task")
 		return
@@ -69,7 +93,7 @@ func (api *API) GetTask(w http.ResponseWriter, r *http.Request) {
 
 // CreateTask creates a new task
 func (api *API) CreateTask(w http.ResponseWriter, r *http.Request) {
-	userID := extractUserID(r)
+	owner := extractTaskOwner(r)
 	
 	var input models.TaskInput
 	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
@@ -83,12 +107,21 @@ func (api *API) CreateTask(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	
-	task, err := api.taskService.CreateTask(&input, userID)
+	task, err := api.taskService.CreateTask(r.Context(), &input, owner)
+	if errors.Is(err, services.ErrNoTaskOwner) {
+		respondError(w, http.StatusUnauthorized, "Authentication required")
+		return
+	}
 	if err != nil {
 		respondError(w, http.StatusInternalServerError, "Failed to create task")
 		return
 	}
 	
+	// Guests get a token to claim their tasks when they sign up or log in
+	if owner.UserID == 0 {
+		w.Header().Set("X-Guest-Claim-Token", api.guestService.Sign(owner.GuestID))
+	}
+	
 	respondJSON(w, http.StatusCreated, task)
 }
 
@@ -100,7 +133,7 @@ func (api *API) UpdateTask(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	
-	userID := extractUserID(r)
+	owner := extractTaskOwner(r)
 	
 	var input models.TaskInput
 	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
@@ -114,7 +147,11 @@ func (api *API) UpdateTask(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	
-	task, err := api.taskService.UpdateTask(id, &input, userID)
+	task, err := api.taskService.UpdateTask(r.Context(), id, &input, owner)
+	if errors.Is(err, services.ErrNoTaskOwner) {
+		respondError(w, http.StatusUnauthorized, "Authentication required")
+		return
+	}
 	if err != nil {
 		respondError(w, http.StatusInternalServerError, "Failed to update task: "+err.Error())
 		return
@@ -131,9 +168,14 @@ func (api *API) DeleteTask(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	
-	userID := extractUserID(r)
+	owner := extractTaskOwner(r)
 	
-	if err := api.taskService.DeleteTask(id, userID); err != nil {
+	err = api.taskService.DeleteTask(r.Context(), id, owner)
+	if errors.Is(err, services.ErrNoTaskOwner) {
+		respondError(w, http.StatusUnauthorized, "Authentication required")
+		return
+	}
+	if err != nil {
 		respondError(w, http.StatusInternalServerError, "Failed to delete task: "+err.Error())
 		return
 	}
@@ -145,13 +187,30 @@ func (api *API) DeleteTask(w http.ResponseWriter, r *http.Request) {
 
 // extractUserID extracts user ID from request context
 func extractUserID(r *http.Request) int64 {
-	userID, ok := r.Context().Value("userID").(int64)
+	userID, ok := r.Context().Value(userIDKey).(int64)
 	if !ok {
 		return 0 // No user ID or not authenticated
 	}
 	return userID
 }
 
+// extractTaskOwner builds the task owner from the authenticated user or guest session
+func extractTaskOwner(r *http.Request) models.TaskOwner {
+	return models.TaskOwner{
+		UserID:  extractUserID(r),
+		GuestID: extractGuestID(r),
+	}
+}
+
+// extractScopes extracts the scopes granted to the request's credential
+func extractScopes(r *http.Request) []string {
+	scopes, ok := r.Context().Value(scopesKey).([]string)
+	if !ok {
+		return nil // Not authenticated
+	}
+	return scopes
+}
+
 // respondJSON sends a JSON response
 func respondJSON(w http.ResponseWriter, status int, payload interface{}) {
 	w.Header().Set("Content-Type", "application/json")
@@ -162,7 +221,7 @@ func respondJSON(w http.ResponseWriter, status int, payload interface{}) {
 		jsonBytes, err := json.Marshal(payload)
 		if err != nil {
 			// If we can't marshal the response payload, send a simple error
-			log.Printf("Error marshaling JSON response: %v", err)
+			slog.Error("Error marshaling JSON response", "error", err)
 			http.Error(w, `{"error":"Internal server error - unable to generate response"}`, http.StatusInternalServerError)
 			return
 		}
@@ -170,7 +229,7 @@ func respondJSON(w http.ResponseWriter, status int, payload interface{}) {
 		// Write the JSON bytes directly
 		_, err = w.Write(jsonBytes)
 		if err != nil {
-			log.Printf("Error writing response: %v", err)
+			slog.Error("Error writing response", "error", err)
 			// Can't do much here since we've already started writing the response
 		}
 	}
@@ -178,7 +237,13 @@ func respondJSON(w http.ResponseWriter, status int, payload interface{}) {
 
 // respondError sends an error response
 func respondError(w http.ResponseWriter, code int, message string) {
-	respondJSON(w, code, map[string]string{"error": message})
+	body := map[string]string{"error": message}
+	
+	// Quoting the request ID lets support find the request in the logs
+	if requestID := w.Header().Get(requestIDHeader); requestID != "" {
+		body["request_id"] = requestID
+	}
+	respondJSON(w, code, body)
 }
 
 // getNowFormatted returns the current time formatted
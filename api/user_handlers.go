@@ -0,0 +1,106 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/bongo/golang-learnings/models"
+	"github.com/bongo/golang-learnings/services"
+)
+
+// GetProfile returns the authenticated user's profile
+func (api *API) GetProfile(w http.ResponseWriter, r *http.Request) {
+	user, err := api.userService.GetUser(r.Context(), extractUserID(r))
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to retrieve profile")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, user)
+}
+
+// UpdateProfile updates the username and requests an email change
+func (api *API) UpdateProfile(w http.ResponseWriter, r *http.Request) {
+	var input models.ProfileInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	user, err := api.userService.UpdateProfile(r.Context(), extractUserID(r), &input)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, user)
+}
+
+// VerifyEmail confirms an email change using the token from the verification link
+func (api *API) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	user, err := api.userService.VerifyEmail(r.Context(), r.URL.Query().Get("token"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, user)
+}
+
+// ChangePassword changes the password, revokes other sessions and returns a fresh token
+func (api *API) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	var input models.PasswordChangeInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	user, err := api.userService.ChangePassword(r.Context(), extractUserID(r), &input)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Existing tokens were revoked, hand the current session a new one
+	token, err := api.authService.GenerateToken(user)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to generate token")
+		return
+	}
+
+	response := models.AuthResponse{
+		Token: token,
+		User:  *user,
+	}
+	api.startSession(w, &response)
+
+	respondJSON(w, http.StatusOK, response)
+}
+
+// DeleteAccount deletes the authenticated user's account and data
+func (api *API) DeleteAccount(w http.ResponseWriter, r *http.Request) {
+	var input models.AccountDeletionInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	// Tasks and submissions are deleted unless anonymization is requested
+	mode := r.URL.Query().Get("mode")
+	if mode == "" {
+		mode = api.config.AccountDeletionMode
+	}
+
+	if err := api.userService.DeleteAccount(r.Context(), extractUserID(r), input.Password, mode); err != nil {
+		if errors.Is(err, services.ErrUserNotFound) {
+			respondError(w, http.StatusNotFound, "User not found")
+			return
+		}
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	api.endSession(w)
+	respondJSON(w, http.StatusNoContent, nil)
+}
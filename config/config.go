@@ -2,20 +2,170 @@ package config
 
 import (
 	"errors"
+	"net"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/bongo/golang-learnings/logging"
 	"github.com/joho/godotenv"
 )
 
+// Task authentication modes
+const (
+	// TaskAuthRequired only lets authenticated users access task routes
+	TaskAuthRequired = "required"
+	// TaskAuthGuest gives anonymous visitors an isolated guest identity
+	TaskAuthGuest = "guest"
+)
+
+// Ways clients can hold their login session
+const (
+	AuthModeBearer = "bearer" // Token returned in the response body and sent in the Authorization header
+	AuthModeCookie = "cookie" // Token kept in an HttpOnly cookie, state-changing requests need a CSRF token
+	AuthModeBoth   = "both"
+)
+
+// JWT signing algorithms
+const (
+	JWTAlgorithmHS256 = "HS256"
+	JWTAlgorithmRS256 = "RS256"
+	JWTAlgorithmEdDSA = "EdDSA"
+)
+
+// insecureJWTSecrets are placeholder secrets that must never be used in production
+var insecureJWTSecrets = []string{"your-secret-key", "your-secret-key-change-this-in-production"}
+
+// defaultSpamKeywords flag contact messages as spam unless CONTACT_SPAM_KEYWORDS is set
+var defaultSpamKeywords = []string{"viagra", "casino", "crypto investment", "backlinks", "seo services", "loan offer"}
+
 // Config holds all application configuration
 type Config struct {
 	Port        string
 	StaticDir   string
 	LogLevel    string
+	LogFormat   string
 	DatabaseURL string
 	JWTSecret   string
 	Environment string
+
+	// Log file, rotated when it reaches LogMaxSizeMB or a new LogRotateInterval starts.
+	// An empty LogFile logs to the console only.
+	LogFile           string
+	LogMaxSizeMB      int
+	LogRotateInterval time.Duration
+	LogMaxBackups     int
+	LogCompress       bool
+
+	// Redaction of credentials and personal data in logs. LogHTTPBodies also logs
+	// request and response headers and bodies, which is meant for development.
+	LogRedact       bool
+	LogRedactFields []string
+	LogHTTPBodies   bool
+
+	// Asymmetric token signing. JWTVerificationKeys maps key IDs of retired keys to
+	// PEM files so tokens signed before a key rotation remain valid.
+	JWTAlgorithm        string
+	JWTPrivateKeyFile   string
+	JWTKeyID            string
+	JWTVerificationKeys map[string]string
+	// Registered claims of login tokens and the clock skew tolerated when checking them
+	JWTIssuer   string
+	JWTAudience string
+	JWTTTL      time.Duration
+	JWTLeeway   time.Duration
+	// OpenID Connect single sign-on, enabled when OIDCIssuer is set
+	OIDCIssuer       string
+	OIDCClientID     string
+	OIDCClientSecret string
+	OIDCRedirectURL  string
+	OIDCScopes       string
+	OIDCProviderName string // Label of the login button
+	// BaseURL is the public URL of the app, used in links sent by email
+	BaseURL string
+
+	// AuthMode is bearer, cookie or both. API keys are accepted in every mode.
+	AuthMode string
+
+	// TaskAuthMode controls how task routes treat anonymous visitors
+	TaskAuthMode string
+	// GuestSecret signs guest session cookies
+	GuestSecret string
+
+	// Login throttling
+	LoginThrottleStore    string // "memory" or "database"
+	LoginMaxFailures      int
+	LoginMaxFailuresPerIP int
+	LoginBackoffBase      time.Duration
+	LoginBackoffMax       time.Duration
+	LoginLockoutDuration  time.Duration
+
+	// Reverse proxies whose X-Forwarded-For and X-Real-IP headers are trusted. Without
+	// them every client behind a proxy shares its IP for throttling and rate limits.
+	TrustedProxies []*net.IPNet
+
+	// Password policy
+	PasswordMinLength        int
+	PasswordRequireUpper     bool
+	PasswordRequireLower     bool
+	PasswordRequireDigit     bool
+	PasswordRequireSymbol    bool
+	PasswordDisallowIdentity bool
+	BreachedPasswordsFile    string // Optional file of SHA-1 hashes of breached passwords
+
+	// Outgoing email; emails are written to the log when no SMTP host is set
+	SMTPHost     string
+	SMTPPort     string
+	SMTPUsername string
+	SMTPPassword string
+	MailFrom     string
+
+	// Email templates: one subdirectory per locale, falling back to EmailDefaultLocale
+	EmailTemplatesDir  string
+	EmailDefaultLocale string
+
+	// Passwordless sign-in links; MagicLinkLimit links per address per MagicLinkWindow
+	MagicLinkTTL    time.Duration
+	MagicLinkLimit  int
+	MagicLinkWindow time.Duration
+
+	// Contact form spam protection. Forms submitted faster than ContactMinFillTime and
+	// messages with too many links or a spam keyword are kept but flagged as spam.
+	ContactMinFillTime   time.Duration
+	ContactTokenTTL      time.Duration // How long a form token stays valid
+	ContactLimitPerIP    int
+	ContactLimitPerEmail int
+	ContactLimitWindow   time.Duration
+	ContactMaxLinks      int
+	ContactSpamKeywords  []string
+
+	// Staff notifications about new contact messages. A channel is enabled by setting its
+	// destination; failed deliveries are retried with exponential backoff.
+	NotifyEmails        []string
+	NotifyWebhookURL    string
+	NotifyWebhookSecret string // Signs webhook requests
+	NotifyMaxAttempts   int
+	NotifyRetryBackoff  time.Duration
+	NotifyWorkers       int
+	NotifyQueueSize     int
+
+	// AccountDeletionMode is "delete" or "anonymize" for a deleted user's tasks and submissions
+	AccountDeletionMode string
+
+	// Personal data exports
+	ExportDir     string
+	ExportLinkTTL time.Duration
+
+	// Features that can be switched off, from FEATURES_FILE and ENABLE_<FEATURE> variables
+	FeaturesFile string
+	Features     Features
+
+	// Bootstrap admin account, created on startup when no admin exists
+	AdminUsername string
+	AdminEmail    string
+	AdminPassword string
 }
 
 // Load reads configuration from .env file and environment variables
@@ -31,7 +181,110 @@ func Load() (*Config, error) {
 		DatabaseURL: getEnv("DATABASE_URL", "file:./tasks.db"),
 		JWTSecret:   getEnv("JWT_SECRET", "your-secret-key"),
 		Environment: getEnv("ENVIRONMENT", "development"),
+
+		LogFile:           getEnv("LOG_FILE", "server.log"),
+		LogMaxSizeMB:      getEnvInt("LOG_MAX_SIZE_MB", 100),
+		LogRotateInterval: getEnvDuration("LOG_ROTATE_INTERVAL", 0),
+		LogMaxBackups:     getEnvInt("LOG_MAX_BACKUPS", 7),
+		LogCompress:       getEnvBool("LOG_COMPRESS", true),
+
+		LogRedact:       getEnvBool("LOG_REDACT", true),
+		LogRedactFields: getEnvList("LOG_REDACT_FIELDS", nil),
+
+		JWTAlgorithm:        getEnv("JWT_ALGORITHM", JWTAlgorithmHS256),
+		JWTPrivateKeyFile:   getEnv("JWT_PRIVATE_KEY_FILE", ""),
+		JWTKeyID:            getEnv("JWT_KEY_ID", ""),
+		JWTVerificationKeys: getEnvMap("JWT_VERIFICATION_KEYS"),
+		JWTIssuer:           getEnv("JWT_ISSUER", "golang-learnings"),
+		JWTAudience:         getEnv("JWT_AUDIENCE", "golang-learnings-api"),
+		JWTTTL:              getEnvDuration("JWT_TTL", 24*time.Hour),
+		JWTLeeway:           getEnvDuration("JWT_LEEWAY", 30*time.Second),
+
+		OIDCIssuer:       getEnv("OIDC_ISSUER", ""),
+		OIDCClientID:     getEnv("OIDC_CLIENT_ID", ""),
+		OIDCClientSecret: getEnv("OIDC_CLIENT_SECRET", ""),
+		OIDCScopes:       getEnv("OIDC_SCOPES", "openid email profile"),
+		OIDCProviderName: getEnv("OIDC_PROVIDER_NAME", "SSO"),
+
+		AuthMode:     getEnv("AUTH_MODE", AuthModeBearer),
+		TaskAuthMode: getEnv("TASK_AUTH_MODE", TaskAuthGuest),
+
+		LoginThrottleStore:    getEnv("LOGIN_THROTTLE_STORE", "database"),
+		LoginMaxFailures:      getEnvInt("LOGIN_MAX_FAILURES", 5),
+		LoginMaxFailuresPerIP: getEnvInt("LOGIN_MAX_FAILURES_PER_IP", 20),
+		LoginBackoffBase:      getEnvDuration("LOGIN_BACKOFF_BASE", time.Second),
+		LoginBackoffMax:       getEnvDuration("LOGIN_BACKOFF_MAX", time.Minute),
+		LoginLockoutDuration:  getEnvDuration("LOGIN_LOCKOUT_DURATION", 15*time.Minute),
+
+		PasswordMinLength:        getEnvInt("PASSWORD_MIN_LENGTH", 8),
+		PasswordRequireUpper:     getEnvBool("PASSWORD_REQUIRE_UPPER", false),
+		PasswordRequireLower:     getEnvBool("PASSWORD_REQUIRE_LOWER", false),
+		PasswordRequireDigit:     getEnvBool("PASSWORD_REQUIRE_DIGIT", false),
+		PasswordRequireSymbol:    getEnvBool("PASSWORD_REQUIRE_SYMBOL", false),
+		PasswordDisallowIdentity: getEnvBool("PASSWORD_DISALLOW_IDENTITY", true),
+		BreachedPasswordsFile:    getEnv("BREACHED_PASSWORDS_FILE", ""),
+
+		SMTPHost:     getEnv("SMTP_HOST", ""),
+		SMTPPort:     getEnv("SMTP_PORT", "587"),
+		SMTPUsername: getEnv("SMTP_USERNAME", ""),
+		SMTPPassword: getEnv("SMTP_PASSWORD", ""),
+		MailFrom:     getEnv("MAIL_FROM", "no-reply@localhost"),
+
+		EmailTemplatesDir:  getEnv("EMAIL_TEMPLATES_DIR", "./templates/email"),
+		EmailDefaultLocale: getEnv("EMAIL_DEFAULT_LOCALE", "en"),
+
+		MagicLinkTTL:    getEnvDuration("MAGIC_LINK_TTL", 15*time.Minute),
+		MagicLinkLimit:  getEnvInt("MAGIC_LINK_LIMIT", 3),
+		MagicLinkWindow: getEnvDuration("MAGIC_LINK_WINDOW", 15*time.Minute),
+
+		ContactMinFillTime:   getEnvDuration("CONTACT_MIN_FILL_TIME", 3*time.Second),
+		ContactTokenTTL:      getEnvDuration("CONTACT_TOKEN_TTL", 2*time.Hour),
+		ContactLimitPerIP:    getEnvInt("CONTACT_LIMIT_PER_IP", 5),
+		ContactLimitPerEmail: getEnvInt("CONTACT_LIMIT_PER_EMAIL", 3),
+		ContactLimitWindow:   getEnvDuration("CONTACT_LIMIT_WINDOW", time.Hour),
+		ContactMaxLinks:      getEnvInt("CONTACT_MAX_LINKS", 2),
+		ContactSpamKeywords:  getEnvList("CONTACT_SPAM_KEYWORDS", defaultSpamKeywords),
+
+		NotifyEmails:        getEnvList("NOTIFY_EMAILS", nil),
+		NotifyWebhookURL:    getEnv("NOTIFY_WEBHOOK_URL", ""),
+		NotifyWebhookSecret: getEnv("NOTIFY_WEBHOOK_SECRET", ""),
+		NotifyMaxAttempts:   getEnvInt("NOTIFY_MAX_ATTEMPTS", 5),
+		NotifyRetryBackoff:  getEnvDuration("NOTIFY_RETRY_BACKOFF", 2*time.Second),
+		NotifyWorkers:       getEnvInt("NOTIFY_WORKERS", 2),
+		NotifyQueueSize:     getEnvInt("NOTIFY_QUEUE_SIZE", 100),
+
+		AccountDeletionMode: getEnv("ACCOUNT_DELETION_MODE", "delete"),
+
+		ExportDir:     getEnv("EXPORT_DIR", "./exports"),
+		ExportLinkTTL: getEnvDuration("EXPORT_LINK_TTL", 24*time.Hour),
+
+		FeaturesFile: getEnv("FEATURES_FILE", ""),
+
+		AdminUsername: getEnv("ADMIN_USERNAME", "admin"),
+		AdminEmail:    getEnv("ADMIN_EMAIL", ""),
+		AdminPassword: getEnv("ADMIN_PASSWORD", ""),
+	}
+	cfg.GuestSecret = getEnv("GUEST_SECRET", cfg.JWTSecret)
+	cfg.BaseURL = getEnv("BASE_URL", "http://localhost:"+cfg.Port)
+	cfg.OIDCRedirectURL = getEnv("OIDC_REDIRECT_URL", cfg.BaseURL+"/api/auth/oidc/callback")
+	cfg.LogHTTPBodies = getEnvBool("LOG_HTTP_BODIES", cfg.Environment == "development")
+	if cfg.Environment == "production" {
+		cfg.LogFormat = getEnv("LOG_FORMAT", logging.FormatJSON)
+	} else {
+		cfg.LogFormat = getEnv("LOG_FORMAT", logging.FormatText)
+	}
+
+	features, err := loadFeatures(cfg.FeaturesFile)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Features = features
+
+	proxies, err := parseTrustedProxies(getEnvList("TRUSTED_PROXIES", nil))
+	if err != nil {
+		return nil, err
 	}
+	cfg.TrustedProxies = proxies
 
 	// Validate configuration
 	if err := validateConfig(cfg); err != nil {
@@ -49,6 +302,87 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
+// getEnvInt gets an integer environment variable or returns default value
+func getEnvInt(key string, defaultValue int) int {
+	if value, exists := os.LookupEnv(key); exists {
+		if parsed, err := strconv.Atoi(value); err == nil {
+			return parsed
+		}
+	}
+	return defaultValue
+}
+
+// getEnvBool gets a boolean environment variable (e.g. "true", "0") or returns default value
+func getEnvBool(key string, defaultValue bool) bool {
+	if value, exists := os.LookupEnv(key); exists {
+		if parsed, err := strconv.ParseBool(value); err == nil {
+			return parsed
+		}
+	}
+	return defaultValue
+}
+
+// getEnvMap gets a comma separated list of key=value pairs from an environment variable
+func getEnvMap(key string) map[string]string {
+	values := make(map[string]string)
+	for _, pair := range strings.Split(os.Getenv(key), ",") {
+		name, value, found := strings.Cut(strings.TrimSpace(pair), "=")
+		if found && name != "" {
+			values[name] = value
+		}
+	}
+	return values
+}
+
+// getEnvList gets a comma separated list from an environment variable or returns default value.
+// An empty variable gives an empty list.
+func getEnvList(key string, defaultValue []string) []string {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return defaultValue
+	}
+
+	values := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			values = append(values, item)
+		}
+	}
+	return values
+}
+
+// parseTrustedProxies parses a list of CIDRs such as "10.0.0.0/8"; a single IP
+// stands for itself
+func parseTrustedProxies(values []string) ([]*net.IPNet, error) {
+	proxies := make([]*net.IPNet, 0, len(values))
+	for _, value := range values {
+		if ip := net.ParseIP(value); ip != nil {
+			bits := 8 * len(ip.To16())
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 32
+			}
+			proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, errors.New("invalid TRUSTED_PROXIES entry (expected an IP or CIDR): " + value)
+		}
+		proxies = append(proxies, network)
+	}
+	return proxies, nil
+}
+
+// getEnvDuration gets a duration environment variable (e.g. "15m") or returns default value
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	if value, exists := os.LookupEnv(key); exists {
+		if parsed, err := time.ParseDuration(value); err == nil {
+			return parsed
+		}
+	}
+	return defaultValue
+}
+
 // validateConfig validates essential configuration parameters
 func validateConfig(cfg *Config) error {
 	// Verify the static directory exists
@@ -63,5 +397,113 @@ func validateConfig(cfg *Config) error {
 	}
 	cfg.StaticDir = absPath
 
+	// Verify token signing settings
+	switch cfg.JWTAlgorithm {
+	case JWTAlgorithmHS256:
+	case JWTAlgorithmRS256, JWTAlgorithmEdDSA:
+		if cfg.JWTPrivateKeyFile == "" {
+			return errors.New("JWT_PRIVATE_KEY_FILE is required for JWT_ALGORITHM " + cfg.JWTAlgorithm)
+		}
+	default:
+		return errors.New("invalid JWT_ALGORITHM (expected HS256, RS256 or EdDSA): " + cfg.JWTAlgorithm)
+	}
+	if cfg.JWTIssuer == "" || cfg.JWTAudience == "" {
+		return errors.New("JWT_ISSUER and JWT_AUDIENCE must not be empty")
+	}
+	if cfg.JWTTTL <= 0 {
+		return errors.New("JWT_TTL must be positive")
+	}
+	if cfg.JWTLeeway < 0 {
+		return errors.New("JWT_LEEWAY must not be negative")
+	}
+
+	// Verify logging settings
+	if _, err := logging.ParseLevel(cfg.LogLevel); err != nil {
+		return errors.New("invalid LOG_LEVEL (expected debug, info, warn or error): " + cfg.LogLevel)
+	}
+	if cfg.LogFormat != logging.FormatText && cfg.LogFormat != logging.FormatJSON {
+		return errors.New("invalid LOG_FORMAT (expected text or json): " + cfg.LogFormat)
+	}
+
+	if cfg.LogMaxSizeMB < 0 || cfg.LogRotateInterval < 0 || cfg.LogMaxBackups < 0 {
+		return errors.New("LOG_MAX_SIZE_MB, LOG_ROTATE_INTERVAL and LOG_MAX_BACKUPS must not be negative")
+	}
+
+	// Logs in production must never contain credentials or personal data
+	if cfg.Environment == "production" && !cfg.LogRedact {
+		return errors.New("LOG_REDACT cannot be turned off in production")
+	}
+
+	// The secret also signs guest sessions and download links, so it must be
+	// changed in production whatever the token algorithm
+	if cfg.Environment == "production" {
+		for _, insecure := range insecureJWTSecrets {
+			if cfg.JWTSecret == insecure || cfg.JWTSecret == "" {
+				return errors.New("JWT_SECRET must be changed from its default value in production")
+			}
+		}
+	}
+
+	// Emails would otherwise only be written to the log
+	if cfg.Environment == "production" && cfg.SMTPHost == "" {
+		return errors.New("SMTP_HOST is required in production")
+	}
+
+	// Verify single sign-on settings
+	if cfg.OIDCIssuer != "" && cfg.OIDCClientID == "" {
+		return errors.New("OIDC_CLIENT_ID is required when OIDC_ISSUER is set")
+	}
+
+	// Verify the session mode
+	if cfg.AuthMode != AuthModeBearer && cfg.AuthMode != AuthModeCookie && cfg.AuthMode != AuthModeBoth {
+		return errors.New("invalid AUTH_MODE (expected bearer, cookie or both): " + cfg.AuthMode)
+	}
+
+	// Verify the task authentication mode
+	if cfg.TaskAuthMode != TaskAuthRequired && cfg.TaskAuthMode != TaskAuthGuest {
+		return errors.New("invalid TASK_AUTH_MODE (expected required or guest): " + cfg.TaskAuthMode)
+	}
+
+	// Verify sign-in link settings
+	if cfg.MagicLinkTTL <= 0 || cfg.MagicLinkLimit < 1 || cfg.MagicLinkWindow <= 0 {
+		return errors.New("MAGIC_LINK_TTL, MAGIC_LINK_LIMIT and MAGIC_LINK_WINDOW must be positive")
+	}
+
+	// Verify contact form spam protection settings
+	if cfg.ContactMinFillTime < 0 || cfg.ContactMaxLinks < 0 {
+		return errors.New("CONTACT_MIN_FILL_TIME and CONTACT_MAX_LINKS must not be negative")
+	}
+	if cfg.ContactTokenTTL <= cfg.ContactMinFillTime {
+		return errors.New("CONTACT_TOKEN_TTL must be longer than CONTACT_MIN_FILL_TIME")
+	}
+	if cfg.ContactLimitPerIP < 1 || cfg.ContactLimitPerEmail < 1 || cfg.ContactLimitWindow <= 0 {
+		return errors.New("CONTACT_LIMIT_PER_IP, CONTACT_LIMIT_PER_EMAIL and CONTACT_LIMIT_WINDOW must be positive")
+	}
+
+	// Verify notification settings
+	if cfg.NotifyWebhookURL != "" && cfg.NotifyWebhookSecret == "" {
+		return errors.New("NOTIFY_WEBHOOK_SECRET is required when NOTIFY_WEBHOOK_URL is set")
+	}
+	if cfg.NotifyMaxAttempts < 1 || cfg.NotifyRetryBackoff <= 0 || cfg.NotifyWorkers < 1 || cfg.NotifyQueueSize < 1 {
+		return errors.New("NOTIFY_MAX_ATTEMPTS, NOTIFY_RETRY_BACKOFF, NOTIFY_WORKERS and NOTIFY_QUEUE_SIZE must be positive")
+	}
+
+	// Verify the account deletion mode
+	if cfg.AccountDeletionMode != "delete" && cfg.AccountDeletionMode != "anonymize" {
+		return errors.New("invalid ACCOUNT_DELETION_MODE (expected delete or anonymize): " + cfg.AccountDeletionMode)
+	}
+
+	// Verify the breached password list exists
+	if cfg.BreachedPasswordsFile != "" {
+		if _, err := os.Stat(cfg.BreachedPasswordsFile); err != nil {
+			return errors.New("breached passwords file is not readable: " + cfg.BreachedPasswordsFile)
+		}
+	}
+
+	// Verify the login throttle store
+	if cfg.LoginThrottleStore != "memory" && cfg.LoginThrottleStore != "database" {
+		return errors.New("invalid LOGIN_THROTTLE_STORE (expected memory or database): " + cfg.LoginThrottleStore)
+	}
+
 	return nil
 }
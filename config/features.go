@@ -0,0 +1,88 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Feature names a part of the app that can be switched off
+type Feature string
+
+const (
+	FeatureContactForm            Feature = "contact_form"            // Public contact form
+	FeatureContactAcknowledgement Feature = "contact_acknowledgement" // Confirmation email to contact form senders
+	FeatureMagicLinks             Feature = "magic_links"             // Passwordless sign-in links
+	FeatureDataExport             Feature = "data_export"             // Personal data exports
+)
+
+// featureDefaults lists every feature and whether it is enabled when not configured
+var featureDefaults = map[Feature]bool{
+	FeatureContactForm:            true,
+	FeatureContactAcknowledgement: true,
+	FeatureMagicLinks:             true,
+	FeatureDataExport:             true,
+}
+
+// Features records which features are enabled
+type Features map[Feature]bool
+
+// Enabled reports whether the feature is switched on
+func (f Features) Enabled(feature Feature) bool {
+	return f[feature]
+}
+
+// loadFeatures starts from the defaults, applies the optional JSON file of feature
+// names to booleans, then the ENABLE_<FEATURE> environment variables
+func loadFeatures(file string) (Features, error) {
+	features := Features{}
+	for feature, enabled := range featureDefaults {
+		features[feature] = enabled
+	}
+
+	if file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read FEATURES_FILE: %w", err)
+		}
+
+		var fromFile map[Feature]bool
+		if err := json.Unmarshal(data, &fromFile); err != nil {
+			return nil, fmt.Errorf("invalid FEATURES_FILE %s: %w", file, err)
+		}
+		for feature, enabled := range fromFile {
+			if _, known := featureDefaults[feature]; !known {
+				return nil, fmt.Errorf("unknown feature in FEATURES_FILE: %s (expected one of %s)", feature, knownFeatures())
+			}
+			features[feature] = enabled
+		}
+	}
+
+	for feature := range featureDefaults {
+		key := "ENABLE_" + strings.ToUpper(string(feature))
+		value, exists := os.LookupEnv(key)
+		if !exists || value == "" {
+			continue
+		}
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s (expected true or false): %s", key, value)
+		}
+		features[feature] = enabled
+	}
+
+	return features, nil
+}
+
+// knownFeatures lists the feature names for error messages
+func knownFeatures() string {
+	names := make([]string, 0, len(featureDefaults))
+	for feature := range featureDefaults {
+		names = append(names, string(feature))
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
@@ -1,19 +1,20 @@
 package db
 
 import (
-	"log"
+	"log/slog"
 
 	"github.com/bongo/golang-learnings/models"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
-	"gorm.io/gorm/logger"
 )
 
-// InitDB initializes the database connection and performs migrations
-func InitDB(dbURL string) (*gorm.DB, error) {
+// InitDB initializes the database connection and performs migrations. Queries are
+// logged at debug level; with redactSQL the log shows placeholders instead of values,
+// which include email addresses, password hashes and tokens.
+func InitDB(dbURL string, redactSQL bool) (*gorm.DB, error) {
 	// Configure GORM
 	config := &gorm.Config{
-		Logger: logger.Default.LogMode(logger.Info),
+		Logger: newQueryLogger(redactSQL),
 	}
 
 	// Connect to database
@@ -22,12 +23,21 @@ func InitDB(dbURL string) (*gorm.DB, error) {
 		return nil, err
 	}
 
+	// Existing users need normalized identifiers before they get unique indexes
+	if err := normalizeUserIdentifiers(db); err != nil {
+		return nil, err
+	}
+
 	// Migrate the schema
-	err = db.AutoMigrate(&models.Task{}, &models.User{}, &models.Contact{})
+	err = db.AutoMigrate(&models.Task{}, &models.User{}, &models.Contact{}, &models.ContactNote{}, &models.ContactReply{}, &models.APIKey{}, &models.LoginAttempt{}, &models.DataExport{}, &models.Identity{}, &models.MagicLinkToken{})
 	if err != nil {
 		return nil, err
 	}
 
-	log.Println("Database migration completed")
+	if err := runOnce(db, "20240601_repair_rounded_user_ids", repairRoundedUserIDs); err != nil {
+		return nil, err
+	}
+
+	slog.Info("Database migration completed")
 	return db, nil
 }
@@ -0,0 +1,188 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/bongo/golang-learnings/models"
+	"gorm.io/gorm"
+)
+
+// schemaMigration records a one-time data migration that has been applied
+type schemaMigration struct {
+	Name      string `gorm:"primaryKey"`
+	AppliedAt time.Time
+}
+
+func (schemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// runOnce applies a data migration in a transaction unless it was applied before
+func runOnce(db *gorm.DB, name string, migrate func(tx *gorm.DB) error) error {
+	if err := db.AutoMigrate(&schemaMigration{}); err != nil {
+		return err
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		var applied schemaMigration
+		err := tx.First(&applied, "name = ?", name).Error
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		if err := migrate(tx); err != nil {
+			return fmt.Errorf("migration %s failed: %w", name, err)
+		}
+		return tx.Create(&schemaMigration{Name: name, AppliedAt: time.Now()}).Error
+	})
+}
+
+// repairRoundedUserIDs fixes rows owned by user IDs that lost precision. Tokens
+// used to carry the user ID as a float64, so tasks and API keys created through
+// them were stored under the nearest representable float value. When that value
+// is also the ID of another user, or of several users, the owner can't be told
+// apart and the migration fails with a list of the rows to fix by hand.
+func repairRoundedUserIDs(tx *gorm.DB) error {
+	var userIDs []int64
+	if err := tx.Unscoped().Model(&models.User{}).Pluck("id", &userIDs).Error; err != nil {
+		return err
+	}
+
+	// Group users by the ID their tokens used to carry
+	users := make(map[int64]bool, len(userIDs))
+	byRounded := make(map[int64][]int64)
+	for _, id := range userIDs {
+		users[id] = true
+		if rounded := int64(float64(id)); rounded != id {
+			byRounded[rounded] = append(byRounded[rounded], id)
+		}
+	}
+
+	tables := []string{"tasks", "api_keys"}
+	var ambiguous []string
+	for rounded, ids := range byRounded {
+		if len(ids) == 1 && !users[rounded] {
+			continue
+		}
+		for _, table := range tables {
+			var count int64
+			if err := tx.Table(table).Where("user_id = ?", rounded).Count(&count).Error; err != nil {
+				return err
+			}
+			if count > 0 {
+				ambiguous = append(ambiguous, fmt.Sprintf("%d %s with user_id %d could belong to users %v", count, table, rounded, ids))
+			}
+		}
+	}
+	if len(ambiguous) > 0 {
+		return fmt.Errorf("can't tell which user owns some rows, reassign them before upgrading: %s", strings.Join(ambiguous, "; "))
+	}
+
+	for rounded, ids := range byRounded {
+		if len(ids) > 1 || users[rounded] {
+			continue // No rows, checked above
+		}
+		for _, table := range tables {
+			result := tx.Table(table).Where("user_id = ?", rounded).Update("user_id", ids[0])
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected > 0 {
+				slog.Info("Repaired rows owned by user", "table", table, "rows", result.RowsAffected, "user_id", ids[0])
+			}
+		}
+	}
+
+	return nil
+}
+
+// normalizeUserIdentifiers backfills the normalized username and email columns of
+// existing users and adds unique indexes on them. Users whose names
+// or addresses only differ in case can't get the indexes, and users with an "@" in
+// their username couldn't log in, so startup fails with a list of them until an
+// admin resolves the conflicts.
+func normalizeUserIdentifiers(db *gorm.DB) error {
+	migrator := db.Migrator()
+	if !migrator.HasTable(&models.User{}) {
+		return nil // Fresh database, AutoMigrate creates everything
+	}
+	if migrator.HasIndex(&models.User{}, "idx_users_username_normalized") && migrator.HasIndex(&models.User{}, "idx_users_email_normalized") {
+		return nil // Already migrated
+	}
+
+	// Add the columns without their indexes, which can only be created once the
+	// columns are filled in without collisions. The DDL matches what AutoMigrate
+	// generates so it doesn't rebuild the table afterwards.
+	for _, column := range []string{"username_normalized", "email_normalized"} {
+		if !migrator.HasColumn(&models.User{}, column) {
+			if err := db.Exec("ALTER TABLE `users` ADD `" + column + "` text NOT NULL DEFAULT \"\"").Error; err != nil {
+				return err
+			}
+		}
+	}
+
+	var users []models.User
+	if err := db.Select("id", "username", "email").Find(&users).Error; err != nil {
+		return err
+	}
+	for _, user := range users {
+		err := db.Model(&models.User{}).Where("id = ?", user.ID).UpdateColumns(map[string]interface{}{
+			"username_normalized": models.NormalizeIdentifier(user.Username),
+			"email_normalized":    models.NormalizeIdentifier(user.Email),
+		}).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	var collisions []string
+	for _, column := range []string{"username_normalized", "email_normalized"} {
+		var groups []struct {
+			Value string
+			IDs   string
+		}
+		err := db.Model(&models.User{}).
+			Select(column + " AS value, GROUP_CONCAT(id) AS ids").
+			Group(column).
+			Having("COUNT(*) > 1").
+			Scan(&groups).Error
+		if err != nil {
+			return err
+		}
+		for _, group := range groups {
+			collisions = append(collisions, fmt.Sprintf("%s %q (user IDs %s)", strings.TrimSuffix(column, "_normalized"), group.Value, group.IDs))
+		}
+	}
+	if len(collisions) > 0 {
+		return fmt.Errorf("users differ only in the case of their username or email, rename or remove them before upgrading: %s", strings.Join(collisions, "; "))
+	}
+
+	// Logins with an "@" are looked up by email, so these users could no longer log in
+	var atNames []string
+	for _, user := range users {
+		if strings.Contains(user.Username, "@") {
+			atNames = append(atNames, fmt.Sprintf("%q (user ID %d)", user.Username, user.ID))
+		}
+	}
+	if len(atNames) > 0 {
+		return fmt.Errorf("usernames can no longer contain \"@\", rename these users before upgrading: %s", strings.Join(atNames, "; "))
+	}
+
+	for _, index := range []string{"idx_users_username_normalized", "idx_users_email_normalized"} {
+		if !migrator.HasIndex(&models.User{}, index) {
+			if err := migrator.CreateIndex(&models.User{}, index); err != nil {
+				return err
+			}
+		}
+	}
+
+	slog.Info("Normalized usernames and emails", "users", len(users))
+	return nil
+}
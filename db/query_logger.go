@@ -0,0 +1,97 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/bongo/golang-learnings/logging"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"gorm.io/gorm/utils"
+)
+
+// slowQueryThreshold is how long a query may take before it is logged as a warning
+const slowQueryThreshold = 200 * time.Millisecond
+
+// queryLogger writes GORM's logs through the logger of the query's context, so queries
+// run for a request carry its request ID. Queries are logged at debug level, slow ones
+// as warnings and failed ones as errors.
+type queryLogger struct {
+	level     logger.LogLevel
+	redactSQL bool // Log placeholders instead of query parameters
+}
+
+func newQueryLogger(redactSQL bool) *queryLogger {
+	return &queryLogger{
+		level:     logger.Info,
+		redactSQL: redactSQL,
+	}
+}
+
+func (l *queryLogger) LogMode(level logger.LogLevel) logger.Interface {
+	clone := *l
+	clone.level = level
+	return &clone
+}
+
+func (l *queryLogger) Info(ctx context.Context, msg string, data ...interface{}) {
+	if l.level >= logger.Info {
+		logging.FromContext(ctx).Info(fmt.Sprintf(msg, data...), "caller", utils.FileWithLineNum())
+	}
+}
+
+func (l *queryLogger) Warn(ctx context.Context, msg string, data ...interface{}) {
+	if l.level >= logger.Warn {
+		logging.FromContext(ctx).Warn(fmt.Sprintf(msg, data...), "caller", utils.FileWithLineNum())
+	}
+}
+
+func (l *queryLogger) Error(ctx context.Context, msg string, data ...interface{}) {
+	if l.level >= logger.Error {
+		logging.FromContext(ctx).Error(fmt.Sprintf(msg, data...), "caller", utils.FileWithLineNum())
+	}
+}
+
+// Trace logs a finished query
+func (l *queryLogger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	if l.level <= logger.Silent {
+		return
+	}
+
+	elapsed := time.Since(begin)
+	level := slog.LevelDebug
+	switch {
+	case err != nil && !errors.Is(err, gorm.ErrRecordNotFound) && l.level >= logger.Error:
+		level = slog.LevelError
+	case elapsed > slowQueryThreshold && l.level >= logger.Warn:
+		level = slog.LevelWarn
+	}
+
+	log := logging.FromContext(ctx)
+	if !log.Enabled(ctx, level) {
+		return
+	}
+
+	sql, rows := fc()
+	attrs := []any{
+		"sql", sql,
+		"rows", rows,
+		"duration", elapsed,
+		"caller", utils.FileWithLineNum(),
+	}
+	if err != nil {
+		attrs = append(attrs, "error", err)
+	}
+	log.Log(ctx, level, "Query", attrs...)
+}
+
+// ParamsFilter drops query parameters from logged SQL when redaction is on
+func (l *queryLogger) ParamsFilter(ctx context.Context, sql string, params ...interface{}) (string, []interface{}) {
+	if l.redactSQL {
+		return sql, nil
+	}
+	return sql, params
+}
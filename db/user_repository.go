@@ -32,7 +32,7 @@ func (r *UserRepository) GetByID(id int64) (*models.User, error) {
 // GetByUsername retrieves a user by username
 func (r *UserRepository) GetByUsername(username string) (*models.User, error) {
 	var user models.User
-	if err := r.DB.Where("username = ?", username).First(&user).Error; err != nil {
+	if err := r.DB.Where("username_normalized = ?", models.NormalizeIdentifier(username)).First(&user).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return nil, nil
 		}
@@ -44,7 +44,7 @@ func (r *UserRepository) GetByUsername(username string) (*models.User, error) {
 // GetByEmail retrieves a user by email
 func (r *UserRepository) GetByEmail(email string) (*models.User, error) {
 	var user models.User
-	if err := r.DB.Where("email = ?", email).First(&user).Error; err != nil {
+	if err := r.DB.Where("email_normalized = ?", models.NormalizeIdentifier(email)).First(&user).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return nil, nil
 		}
@@ -71,7 +71,7 @@ func (r *UserRepository) Delete(id int64) error {
 // Exists checks if a user exists by username or email
 func (r *UserRepository) Exists(username, email string) (bool, error) {
 	var count int64
-	err := r.DB.Model(&models.User{}).Where("username = ? OR email = ?", username, email).Count(&count).Error
+	err := r.DB.Model(&models.User{}).Where("username_normalized = ? OR email_normalized = ?", models.NormalizeIdentifier(username), models.NormalizeIdentifier(email)).Count(&count).Error
 	if err != nil {
 		return false, err
 	}
@@ -0,0 +1,208 @@
+// Package logging keeps personal data and credentials out of the logs.
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Redacted replaces the value of a sensitive field
+const Redacted = "[REDACTED]"
+
+// maxLoggedBody is how much of a request or response body is logged
+const maxLoggedBody = 2048
+
+// sensitiveFields are header, query parameter and JSON field names whose values are
+// always redacted. Names are compared lowercased without dashes and underscores, so
+// "X-API-Key", "api_key" and "apiKey" are the same field.
+var sensitiveFields = []string{
+	"authorization", "proxyauthorization", "cookie", "setcookie",
+	"apikey", "xapikey", "code", "signature", "name", "message",
+}
+
+// sensitiveFragments redact any field whose name contains them, such as
+// "new_password", "refresh_token", "client_secret" or "pending_email"
+var sensitiveFragments = []string{"password", "secret", "token", "email"}
+
+// Patterns for sensitive values in free text
+var (
+	emailPattern  = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+	bearerPattern = regexp.MustCompile(`(?i)\b(bearer|basic)\s+[A-Za-z0-9._~+/=\-]+`)
+	jwtPattern    = regexp.MustCompile(`eyJ[A-Za-z0-9_\-]+\.[A-Za-z0-9_\-]+\.[A-Za-z0-9_\-]*`)
+	urlPattern    = regexp.MustCompile(`https?://[^\s"'<>]+\?[^\s"'<>]+`)
+)
+
+// Redactor masks credentials and personal data before they are logged. Field rules
+// redact values by name (headers, query parameters and JSON fields); value rules find
+// email addresses and tokens anywhere in the text. A disabled Redactor passes
+// everything through unchanged.
+type Redactor struct {
+	enabled bool
+	fields  map[string]bool
+}
+
+// NewRedactor creates a redactor with the built-in rules plus extra field names
+func NewRedactor(enabled bool, extraFields []string) *Redactor {
+	fields := make(map[string]bool)
+	for _, field := range sensitiveFields {
+		fields[field] = true
+	}
+	for _, field := range extraFields {
+		if field = normalizeField(field); field != "" {
+			fields[field] = true
+		}
+	}
+
+	return &Redactor{
+		enabled: enabled,
+		fields:  fields,
+	}
+}
+
+// Enabled reports whether values are being redacted
+func (r *Redactor) Enabled() bool {
+	return r.enabled
+}
+
+// IsSensitive reports whether values of the named field must not be logged
+func (r *Redactor) IsSensitive(field string) bool {
+	field = normalizeField(field)
+	if r.fields[field] {
+		return true
+	}
+	for _, fragment := range sensitiveFragments {
+		if strings.Contains(field, fragment) {
+			return true
+		}
+	}
+	return false
+}
+
+// String masks email addresses, authorization credentials, JWTs and sensitive query
+// parameters of links in free text
+func (r *Redactor) String(s string) string {
+	if !r.enabled {
+		return s
+	}
+
+	s = urlPattern.ReplaceAllStringFunc(s, r.link)
+	s = bearerPattern.ReplaceAllString(s, "$1 "+Redacted)
+	s = jwtPattern.ReplaceAllString(s, Redacted)
+	return emailPattern.ReplaceAllString(s, "[EMAIL]")
+}
+
+// link redacts the query parameters of a link such as "/verify?token=..."
+func (r *Redactor) link(link string) string {
+	base, query, _ := strings.Cut(link, "?")
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return base + "?" + Redacted
+	}
+	return base + "?" + r.Query(values)
+}
+
+// Headers formats headers for logging, redacting sensitive ones
+func (r *Redactor) Headers(header http.Header) string {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		value := strings.Join(header[name], ", ")
+		if r.enabled && r.IsSensitive(name) {
+			value = Redacted
+		} else {
+			value = r.String(value)
+		}
+		parts = append(parts, name+": "+value)
+	}
+	return strings.Join(parts, "; ")
+}
+
+// Query formats query parameters for logging, redacting sensitive ones
+func (r *Redactor) Query(values url.Values) string {
+	if !r.enabled || len(values) == 0 {
+		return values.Encode()
+	}
+
+	redacted := make(url.Values, len(values))
+	for name, list := range values {
+		for _, value := range list {
+			if r.IsSensitive(name) {
+				value = Redacted
+			} else {
+				value = r.String(value)
+			}
+			redacted.Add(name, value)
+		}
+	}
+	// Encode would escape the brackets of the placeholder
+	return strings.ReplaceAll(redacted.Encode(), url.QueryEscape(Redacted), Redacted)
+}
+
+// Body formats a request or response body for logging. JSON bodies have sensitive
+// fields redacted at any depth; other bodies get the free text rules. Long bodies
+// are truncated.
+func (r *Redactor) Body(body []byte) string {
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 {
+		return ""
+	}
+
+	text := string(body)
+	if r.enabled {
+		var value interface{}
+		decoder := json.NewDecoder(bytes.NewReader(body))
+		decoder.UseNumber()
+		if err := decoder.Decode(&value); err == nil {
+			if redacted, err := json.Marshal(r.redactJSON(value)); err == nil {
+				text = string(redacted)
+			}
+		} else {
+			text = r.String(text)
+		}
+	}
+
+	if len(text) > maxLoggedBody {
+		text = text[:maxLoggedBody] + "...(truncated)"
+	}
+	return text
+}
+
+// redactJSON walks a decoded JSON value, replacing sensitive fields
+func (r *Redactor) redactJSON(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, field := range v {
+			if r.IsSensitive(key) {
+				v[key] = Redacted
+			} else {
+				v[key] = r.redactJSON(field)
+			}
+		}
+		return v
+	case []interface{}:
+		for i, item := range v {
+			v[i] = r.redactJSON(item)
+		}
+		return v
+	case string:
+		return r.String(v)
+	default:
+		return v
+	}
+}
+
+// normalizeField lowercases a field name and drops dashes and underscores
+func normalizeField(field string) string {
+	field = strings.ToLower(strings.TrimSpace(field))
+	return strings.NewReplacer("-", "", "_", "").Replace(field)
+}
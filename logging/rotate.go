@@ -0,0 +1,286 @@
+package logging
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupTimeFormat is the timestamp in rotated file names. It sorts chronologically
+// and contains no characters that are awkward in file names.
+const backupTimeFormat = "2006-01-02T15-04-05.000"
+
+// RotateOptions control when a RotatingFile is rotated and how many old files are kept
+type RotateOptions struct {
+	MaxSize    int64         // Rotate before the file grows beyond this many bytes; 0 disables
+	Interval   time.Duration // Rotate when a new interval starts, e.g. 24h rotates at midnight UTC; 0 disables
+	MaxBackups int           // Rotated files to keep; 0 keeps them all
+	Compress   bool          // Gzip rotated files
+}
+
+// RotatingFile is a log file that rotates itself. Rotated files are renamed with a
+// timestamp ("server.log" becomes "server-2024-01-02T15-04-05.000.log"), optionally
+// compressed, and the oldest removed beyond MaxBackups. Compression and removal run
+// in the background. Reopen supports external tools such as logrotate.
+type RotatingFile struct {
+	path    string
+	options RotateOptions
+
+	mu       sync.Mutex
+	file     *os.File
+	size     int64
+	periodAt time.Time // Start of the interval the file was written in
+
+	millMu sync.Mutex
+	wg     sync.WaitGroup
+}
+
+// OpenRotatingFile opens or creates the log file, appending to it
+func OpenRotatingFile(path string, options RotateOptions) (*RotatingFile, error) {
+	f := &RotatingFile{
+		path:    path,
+		options: options,
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Write appends to the file, rotating it first when it is due. When the rotation
+// fails p is still written to the current file, and the rotation error returned.
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+
+	var rotateErr error
+	if f.due(int64(len(p))) {
+		rotateErr = f.rotate()
+		if f.file == nil {
+			return 0, rotateErr
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	if err == nil {
+		err = rotateErr
+	}
+	return n, err
+}
+
+// Rotate rotates the file now
+func (f *RotatingFile) Rotate() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.rotate()
+}
+
+// Reopen closes the file and opens the path again. Send SIGHUP after an external tool
+// has moved the file away so logging continues in a new one.
+func (f *RotatingFile) Reopen() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file != nil {
+		f.file.Close()
+		f.file = nil
+	}
+	return f.open()
+}
+
+// Close closes the file once pending compression and cleanup have finished
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	var err error
+	if f.file != nil {
+		err = f.file.Close()
+		f.file = nil
+	}
+	f.mu.Unlock()
+
+	f.wg.Wait()
+	return err
+}
+
+// open opens the file at the path, continuing the interval it was last written in
+func (f *RotatingFile) open() error {
+	if err := os.MkdirAll(filepath.Dir(f.path), 0755); err != nil {
+		return fmt.Errorf("failed to create log directory: %w", err)
+	}
+
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+
+	f.file = file
+	f.size = info.Size()
+	f.periodAt = f.period(info.ModTime())
+	if info.Size() == 0 {
+		f.periodAt = f.period(time.Now())
+	}
+	return nil
+}
+
+// due reports whether the file must be rotated before writing n more bytes
+func (f *RotatingFile) due(n int64) bool {
+	if f.options.MaxSize > 0 && f.size > 0 && f.size+n > f.options.MaxSize {
+		return true
+	}
+	return f.options.Interval > 0 && f.period(time.Now()).After(f.periodAt)
+}
+
+// period returns the start of the rotation interval containing t
+func (f *RotatingFile) period(t time.Time) time.Time {
+	if f.options.Interval <= 0 {
+		return time.Time{}
+	}
+	return t.UTC().Truncate(f.options.Interval)
+}
+
+// rotate moves the current file aside and starts a new one. The caller holds f.mu.
+func (f *RotatingFile) rotate() error {
+	if f.file != nil {
+		if err := f.file.Close(); err != nil {
+			return err
+		}
+		f.file = nil
+	}
+
+	// The file may already have been moved away by another tool
+	backup := f.backupName(time.Now())
+	renameErr := os.Rename(f.path, backup)
+	if renameErr != nil && !os.IsNotExist(renameErr) {
+		// Keep logging to the old file rather than losing entries. Restarting the
+		// size and interval counts waits for another MaxSize bytes or the next
+		// interval before trying again, instead of retrying on every write.
+		if err := f.open(); err != nil {
+			return err
+		}
+		f.size = 0
+		f.periodAt = f.period(time.Now())
+		return fmt.Errorf("failed to rotate log file: %w", renameErr)
+	}
+
+	if err := f.open(); err != nil {
+		return err
+	}
+	if renameErr != nil {
+		return nil
+	}
+
+	f.wg.Add(1)
+	go f.mill(backup)
+	return nil
+}
+
+// backupName is the name a file rotated at t gets
+func (f *RotatingFile) backupName(t time.Time) string {
+	ext := filepath.Ext(f.path)
+	prefix := strings.TrimSuffix(f.path, ext)
+	return prefix + "-" + t.UTC().Format(backupTimeFormat) + ext
+}
+
+// mill compresses a freshly rotated file and removes backups beyond MaxBackups. It
+// runs in the background without holding f.mu, so it can log its failures.
+func (f *RotatingFile) mill(backup string) {
+	defer f.wg.Done()
+	f.millMu.Lock()
+	defer f.millMu.Unlock()
+
+	if f.options.Compress {
+		if err := compressFile(backup); err != nil {
+			slog.Error("Error compressing rotated log file", "file", backup, "error", err)
+		}
+	}
+
+	if f.options.MaxBackups <= 0 {
+		return
+	}
+	backups, err := f.backups()
+	if err != nil {
+		slog.Error("Error listing rotated log files", "error", err)
+		return
+	}
+	for len(backups) > f.options.MaxBackups {
+		if err := os.Remove(backups[0]); err != nil {
+			slog.Error("Error removing rotated log file", "file", backups[0], "error", err)
+		}
+		backups = backups[1:]
+	}
+}
+
+// backups lists rotated files, oldest first
+func (f *RotatingFile) backups() ([]string, error) {
+	ext := filepath.Ext(f.path)
+	prefix := filepath.Base(strings.TrimSuffix(f.path, ext)) + "-"
+	entries, err := os.ReadDir(filepath.Dir(f.path))
+	if err != nil {
+		return nil, err
+	}
+
+	var backups []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		stamp := strings.TrimSuffix(strings.TrimSuffix(name, ".gz"), ext)
+		if _, err := time.Parse(backupTimeFormat, strings.TrimPrefix(stamp, prefix)); err != nil {
+			continue // Not one of ours
+		}
+		backups = append(backups, filepath.Join(filepath.Dir(f.path), name))
+	}
+	sort.Strings(backups)
+	return backups, nil
+}
+
+// compressFile gzips a file to <name>.gz and removes the original
+func compressFile(name string) error {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(name+".gz", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(dst)
+	if _, err := io.Copy(gz, src); err != nil {
+		dst.Close()
+		os.Remove(name + ".gz")
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		dst.Close()
+		os.Remove(name + ".gz")
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(name + ".gz")
+		return err
+	}
+
+	src.Close()
+	return os.Remove(name)
+}
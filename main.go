@@ -2,7 +2,8 @@ package main
 
 import (
 	"context"
-	"log"
+	"io"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
@@ -12,23 +13,27 @@ import (
 	"github.com/bongo/golang-learnings/api"
 	"github.com/bongo/golang-learnings/config"
 	"github.com/bongo/golang-learnings/db"
+	"github.com/bongo/golang-learnings/logging"
 )
 
 func main() {
 	// Load configuration
 	cfg, err := config.Load()
 	if err != nil {
-		log.Fatalf("Failed to load configuration: %v", err)
+		fatal("Failed to load configuration", err)
 	}
 
 	// Setup logging
-	logFile := setupLogging(cfg.LogLevel)
-	defer logFile.Close()
+	logFile := setupLogging(cfg)
+	if logFile != nil {
+		defer logFile.Close()
+		go reopenLogOnHangup(logFile)
+	}
 
 	// Initialize database
-	database, err := db.InitDB(cfg.DatabaseURL)
+	database, err := db.InitDB(cfg.DatabaseURL, cfg.LogRedact)
 	if err != nil {
-		log.Fatalf("Failed to connect to database: %v", err)
+		fatal("Failed to connect to database", err)
 	}
 
 	// Initialize repositories
@@ -37,16 +42,20 @@ func main() {
 	contactRepo := db.NewContactRepository(database)
 
 	// Create and configure the server
-	server := api.NewServer(cfg, taskRepo, userRepo, contactRepo)
+	server, err := api.NewServer(cfg, taskRepo, userRepo, contactRepo)
+	if err != nil {
+		fatal("Failed to create server", err)
+	}
 
 	// Start server in a goroutine
 	go func() {
-		log.Printf("Server started on http://localhost:%s", cfg.Port)
-		log.Printf("Serving files from: %s", cfg.StaticDir)
-		log.Printf("Environment: %s", cfg.Environment)
+		slog.Info("Server started",
+			"url", "http://localhost:"+cfg.Port,
+			"static_dir", cfg.StaticDir,
+			"environment", cfg.Environment)
 
 		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Error starting server: %v", err)
+			fatal("Error starting server", err)
 		}
 	}()
 
@@ -55,26 +64,66 @@ func main() {
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 
-	log.Println("Server shutting down...")
+	slog.Info("Server shutting down")
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
 	if err := server.Shutdown(ctx); err != nil {
-		log.Fatalf("Server forced to shutdown: %v", err)
+		fatal("Server forced to shutdown", err)
 	}
 
-	log.Println("Server exited properly")
+	slog.Info("Server exited properly")
 }
 
-// setupLogging configures application logging to file and console
-func setupLogging(logLevel string) *os.File {
-	logFile, err := os.OpenFile("server.log", os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0666)
-	if err != nil {
-		log.Fatalf("Error opening log file: %v", err)
+// setupLogging configures application logging to file and console. It becomes the
+// default slog logger, which the standard log package also writes to. The log file
+// is nil when LOG_FILE is empty.
+func setupLogging(cfg *config.Config) *logging.RotatingFile {
+	var output io.Writer = os.Stdout
+	var logFile *logging.RotatingFile
+	if cfg.LogFile != "" {
+		var err error
+		logFile, err = logging.OpenRotatingFile(cfg.LogFile, logging.RotateOptions{
+			MaxSize:    int64(cfg.LogMaxSizeMB) << 20,
+			Interval:   cfg.LogRotateInterval,
+			MaxBackups: cfg.LogMaxBackups,
+			Compress:   cfg.LogCompress,
+		})
+		if err != nil {
+			fatal("Error opening log file", err)
+		}
+
+		// Write logs to both file and console
+		output = io.MultiWriter(os.Stdout, logFile)
 	}
 
-	// Write logs to both file and console
-	log.SetOutput(logFile)
-	log.SetFlags(log.LstdFlags | log.Lshortfile)
+	// Validated with the rest of the configuration
+	level, _ := logging.ParseLevel(cfg.LogLevel)
+
+	logger, err := logging.New(output, cfg.LogFormat, level)
+	if err != nil {
+		fatal("Error setting up logging", err)
+	}
+	slog.SetDefault(logger)
 	return logFile
-}
\ No newline at end of file
+}
+
+// reopenLogOnHangup reopens the log file on SIGHUP, which external tools such as
+// logrotate send after moving the file away
+func reopenLogOnHangup(logFile *logging.RotatingFile) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	for range hangup {
+		if err := logFile.Reopen(); err != nil {
+			slog.Error("Error reopening log file", "error", err)
+			continue
+		}
+		slog.Info("Log file reopened")
+	}
+}
+
+// fatal logs an error that stops the server and exits
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
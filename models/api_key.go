@@ -0,0 +1,32 @@
+package models
+
+import (
+	"time"
+)
+
+// APIKey represents a personal API key used by scripts and integrations.
+// Only a hash of the secret part is stored; the full key is shown once on creation.
+type APIKey struct {
+	ID         int64      `json:"id" gorm:"primaryKey"`
+	UserID     int64      `json:"user_id" gorm:"index;not null"`
+	Name       string     `json:"name" gorm:"not null"`
+	Prefix     string     `json:"prefix" gorm:"uniqueIndex;not null"`
+	SecretHash string     `json:"-" gorm:"not null"` // Never expose the hash in JSON
+	Scopes     string     `json:"scopes"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	CreatedAt  time.Time  `json:"created_at" gorm:"autoCreateTime"`
+}
+
+// APIKeyInput represents the data needed to create an API key
+type APIKeyInput struct {
+	Name      string `json:"name"`
+	Scopes    string `json:"scopes"`
+	ExpiresIn int    `json:"expires_in_days"` // 0 means the key never expires
+}
+
+// APIKeyResponse is returned once when a key is created and carries the plaintext key
+type APIKeyResponse struct {
+	Key    string `json:"key"`
+	APIKey APIKey `json:"api_key"`
+}
@@ -4,18 +4,110 @@ import (
 	"time"
 )
 
+// Contact message statuses, from arrival to being dealt with
+const (
+	ContactStatusNew     = "new"
+	ContactStatusOpen    = "open"
+	ContactStatusReplied = "replied"
+	ContactStatusClosed  = "closed"
+	ContactStatusSpam    = "spam"
+)
+
+// ContactStatuses lists every contact message status
+var ContactStatuses = []string{ContactStatusNew, ContactStatusOpen, ContactStatusReplied, ContactStatusClosed, ContactStatusSpam}
+
+// IsValidContactStatus reports whether status is a known contact message status
+func IsValidContactStatus(status string) bool {
+	for _, s := range ContactStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
 // Contact represents a contact form submission
 type Contact struct {
+	ID         int64      `json:"id" gorm:"primaryKey"`
+	Name       string     `json:"name" gorm:"not null"`
+	Email      string     `json:"email" gorm:"not null"`
+	Message    string     `json:"message" gorm:"not null;type:text"`
+	Status     string     `json:"status" gorm:"not null;default:new;index"`
+	SpamReason string     `json:"spam_reason,omitempty"`    // Why the message was flagged on arrival
+	Locale     string     `json:"locale,omitempty"`         // Language for emails to the sender
+	AssigneeID *int64     `json:"assignee_id" gorm:"index"` // Admin handling the message
+	ReadAt     *time.Time `json:"read_at" gorm:"index"`
+	CreatedAt  time.Time  `json:"created_at" gorm:"autoCreateTime"`
+
+	// Only loaded when viewing a single message in the admin inbox
+	Notes   []ContactNote  `json:"notes,omitempty" gorm:"foreignKey:ContactID"`
+	Replies []ContactReply `json:"replies,omitempty" gorm:"foreignKey:ContactID"`
+}
+
+// ContactNote is an internal note on a contact message, never shown to the sender
+type ContactNote struct {
+	ID        int64     `json:"id" gorm:"primaryKey"`
+	ContactID int64     `json:"contact_id" gorm:"index;not null"`
+	AuthorID  int64     `json:"author_id" gorm:"not null"`
+	Body      string    `json:"body" gorm:"not null;type:text"`
+	CreatedAt time.Time `json:"created_at" gorm:"autoCreateTime"`
+}
+
+// ContactReply is an email sent to the sender of a contact message
+type ContactReply struct {
 	ID        int64     `json:"id" gorm:"primaryKey"`
-	Name      string    `json:"name" gorm:"not null"`
-	Email     string    `json:"email" gorm:"not null"`
-	Message   string    `json:"message" gorm:"not null;type:text"`
+	ContactID int64     `json:"contact_id" gorm:"index;not null"`
+	AuthorID  int64     `json:"author_id" gorm:"not null"`
+	Subject   string    `json:"subject" gorm:"not null"`
+	Body      string    `json:"body" gorm:"not null;type:text"`
 	CreatedAt time.Time `json:"created_at" gorm:"autoCreateTime"`
 }
 
 // ContactInput represents the data submitted through the contact form
 type ContactInput struct {
-	Name    string `json:"name"`
-	Email   string `json:"email"`
-	Message string `json:"message"`
+	Name      string `json:"name"`
+	Email     string `json:"email"`
+	Message   string `json:"message"`
+	FormToken string `json:"form_token"` // From GET /api/contact/token when the form was loaded
+	Website   string `json:"website"`    // Honeypot field hidden from people
+	Locale    string `json:"locale"`     // Defaults to the request's Accept-Language
+}
+
+// ContactFilter narrows down the contact messages listed in the admin inbox
+type ContactFilter struct {
+	Search     string     // Matches name, email or message text
+	Since      *time.Time // Received at or after
+	Until      *time.Time // Received before
+	Read       *bool      // Only read or only unread messages
+	Status     string
+	AssigneeID *int64 // Only messages assigned to this admin, or unassigned ones when 0
+	Page       int
+	Limit      int
+}
+
+// ContactPage is a page of contact messages returned by admin searches
+type ContactPage struct {
+	Contacts []Contact `json:"contacts"`
+	Total    int64     `json:"total"`
+	Unread   int64     `json:"unread"` // Unread messages in the whole inbox
+	Page     int       `json:"page"`
+	Limit    int       `json:"limit"`
+}
+
+// ContactUpdateInput changes the status or assignee of a contact message.
+// An assignee_id of 0 unassigns the message.
+type ContactUpdateInput struct {
+	Status     *string `json:"status"`
+	AssigneeID *int64  `json:"assignee_id"`
+}
+
+// ContactNoteInput is a new internal note
+type ContactNoteInput struct {
+	Body string `json:"body"`
+}
+
+// ContactReplyInput is a reply to the sender of a contact message
+type ContactReplyInput struct {
+	Subject string `json:"subject"` // Optional; defaults to a generic subject
+	Body    string `json:"body"`
 }
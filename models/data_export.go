@@ -0,0 +1,25 @@
+package models
+
+import (
+	"time"
+)
+
+// Data export statuses
+const (
+	ExportPending = "pending"
+	ExportReady   = "ready"
+	ExportFailed  = "failed"
+)
+
+// DataExport is an archive of everything stored about a user, generated asynchronously
+type DataExport struct {
+	ID          int64      `json:"id" gorm:"primaryKey"`
+	UserID      int64      `json:"user_id" gorm:"index;not null"`
+	Status      string     `json:"status" gorm:"not null"`
+	Error       string     `json:"error,omitempty"`
+	FilePath    string     `json:"-"`
+	CreatedAt   time.Time  `json:"created_at" gorm:"autoCreateTime"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"` // When the download link and file expire
+	DownloadURL string     `json:"download_url,omitempty" gorm:"-"`
+}
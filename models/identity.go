@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// Identity links a user to an account at an external identity provider
+type Identity struct {
+	ID          int64     `json:"id" gorm:"primaryKey"`
+	UserID      int64     `json:"user_id" gorm:"index;not null"`
+	Provider    string    `json:"provider" gorm:"uniqueIndex:idx_identity_provider_subject;not null"` // Issuer URL of the provider
+	Subject     string    `json:"subject" gorm:"uniqueIndex:idx_identity_provider_subject;not null"`  // Stable user ID at the provider
+	Email       string    `json:"email"`
+	CreatedAt   time.Time `json:"created_at" gorm:"autoCreateTime"`
+	LastLoginAt time.Time `json:"last_login_at"`
+}
+
+// AuthProvider describes an external login option offered to the frontend
+type AuthProvider struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	LoginURL string `json:"login_url"`
+}
@@ -0,0 +1,13 @@
+package models
+
+import (
+	"time"
+)
+
+// LoginAttempt tracks failed logins for one account or client IP
+type LoginAttempt struct {
+	Key           string    `json:"key" gorm:"primaryKey"`
+	Failures      int       `json:"failures" gorm:"not null;default:0"`
+	LastFailureAt time.Time `json:"last_failure_at"`
+	LockedUntil   time.Time `json:"locked_until"`
+}
@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+)
+
+// MagicLinkToken is a single-use passwordless sign-in link sent by email.
+// Only a hash of the token is stored.
+type MagicLinkToken struct {
+	ID        int64      `json:"id" gorm:"primaryKey"`
+	UserID    int64      `json:"user_id" gorm:"index;not null"`
+	TokenHash string     `json:"-" gorm:"uniqueIndex;not null"`
+	ExpiresAt time.Time  `json:"expires_at" gorm:"not null"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at" gorm:"autoCreateTime"`
+}
+
+// MagicLinkInput is the request for a sign-in link
+type MagicLinkInput struct {
+	Email string `json:"email"`
+}
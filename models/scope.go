@@ -0,0 +1,69 @@
+package models
+
+import (
+	"errors"
+	"strings"
+)
+
+// Scopes limit what a token or API key is allowed to do
+const (
+	ScopeTasksRead    = "tasks:read"
+	ScopeTasksWrite   = "tasks:write"
+	ScopeContactsRead = "contacts:read"
+	ScopeAdmin        = "admin" // Grants every other scope
+)
+
+// AllScopes lists every scope the API understands
+var AllScopes = []string{ScopeTasksRead, ScopeTasksWrite, ScopeContactsRead, ScopeAdmin}
+
+// AdminScopes can only be held by admins. They are dropped from the credentials of
+// users who aren't admins (anymore).
+var AdminScopes = []string{ScopeContactsRead, ScopeAdmin}
+
+// DefaultUserScopes are granted to regular users and to credentials issued without explicit scopes
+var DefaultUserScopes = []string{ScopeTasksRead, ScopeTasksWrite}
+
+// ParseScopes splits a space or comma separated scope list
+func ParseScopes(s string) []string {
+	fields := strings.FieldsFunc(s, func(r rune) bool {
+		return r == ' ' || r == ','
+	})
+
+	scopes := make([]string, 0, len(fields))
+	for _, field := range fields {
+		if !containsScope(scopes, field) {
+			scopes = append(scopes, field)
+		}
+	}
+	return scopes
+}
+
+// ValidateScopes checks that every scope is known
+func ValidateScopes(scopes []string) error {
+	for _, scope := range scopes {
+		if !containsScope(AllScopes, scope) {
+			return errors.New("unknown scope: " + scope)
+		}
+	}
+	return nil
+}
+
+// HasScope reports whether the granted scopes satisfy the required one
+func HasScope(granted []string, required string) bool {
+	return containsScope(granted, ScopeAdmin) || containsScope(granted, required)
+}
+
+// IsAdminScope reports whether only admins may hold the scope
+func IsAdminScope(scope string) bool {
+	return containsScope(AdminScopes, scope)
+}
+
+// containsScope reports whether scope is in the list
+func containsScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
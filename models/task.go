@@ -12,6 +12,13 @@ type Task struct {
 	CreatedAt   time.Time `json:"created_at" gorm:"autoCreateTime"`
 	UpdatedAt   time.Time `json:"updated_at" gorm:"autoUpdateTime"`
 	UserID      int64     `json:"user_id,omitempty" gorm:"index"`
+	GuestID     string    `json:"-" gorm:"index"` // Set for tasks created by anonymous guests
+}
+
+// TaskOwner identifies who tasks belong to: a registered user or an anonymous guest
+type TaskOwner struct {
+	UserID  int64
+	GuestID string
 }
 
 // TaskInput represents the data needed to create or update a task
@@ -1,7 +1,16 @@
 package models
 
 import (
+	"strings"
 	"time"
+
+	"gorm.io/gorm"
+)
+
+// User roles
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
 )
 
 // User represents a user in our system
@@ -9,20 +18,121 @@ type User struct {
 	ID        int64     `json:"id" gorm:"primaryKey"`
 	Username  string    `json:"username" gorm:"uniqueIndex;not null"`
 	Email     string    `json:"email" gorm:"uniqueIndex;not null"`
-	Password  string    `json:"-" gorm:"not null"` // Never expose password in JSON
+	Password  string    `json:"-" gorm:"not null"` // Never expose password in JSON; empty for single sign-on users without one
+	Role      string    `json:"role" gorm:"not null;default:user"`
+	Disabled  bool      `json:"disabled" gorm:"default:false"`
 	CreatedAt time.Time `json:"created_at" gorm:"autoCreateTime"`
 	UpdatedAt time.Time `json:"updated_at" gorm:"autoUpdateTime"`
+
+	// Lowercased copies of Username and Email, kept in sync by BeforeSave. Lookups
+	// use these so names and addresses are unique regardless of case.
+	UsernameNormalized string `json:"-" gorm:"uniqueIndex;not null;default:''"`
+	EmailNormalized    string `json:"-" gorm:"uniqueIndex;not null;default:''"`
+
+	// TokenVersion is embedded in login tokens; bumping it revokes existing sessions
+	TokenVersion int `json:"-" gorm:"not null;default:0"`
+
+	// Email change awaiting confirmation through a link sent to the new address
+	PendingEmail            string     `json:"pending_email,omitempty"`
+	EmailVerificationHash   string     `json:"-"`
+	EmailVerificationExpiry *time.Time `json:"-"`
+}
+
+// BeforeSave keeps the normalized username and email in sync
+func (u *User) BeforeSave(tx *gorm.DB) error {
+	u.UsernameNormalized = NormalizeIdentifier(u.Username)
+	u.EmailNormalized = NormalizeIdentifier(u.Email)
+	return nil
+}
+
+// NormalizeIdentifier returns the form of a username or email used for lookups
+func NormalizeIdentifier(s string) string {
+	return strings.ToLower(strings.TrimSpace(s))
+}
+
+// IsAdmin reports whether the user has the admin role
+func (u *User) IsAdmin() bool {
+	return u.Role == RoleAdmin
+}
+
+// HasPassword reports whether the user has set a password. Users created through
+// single sign-on have none until they set one.
+func (u *User) HasPassword() bool {
+	return u.Password != ""
+}
+
+// Scopes returns the scopes granted to the user's login tokens
+func (u *User) Scopes() []string {
+	if u.IsAdmin() {
+		return append([]string{ScopeAdmin}, DefaultUserScopes...)
+	}
+	return DefaultUserScopes
 }
 
 // UserInput represents user registration/login data
 type UserInput struct {
-	Username string `json:"username"`
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	Identifier string `json:"identifier,omitempty"` // Username or email to log in with
+	Username   string `json:"username"`
+	Email      string `json:"email"`
+	Password   string `json:"password"`
+	ClaimToken string `json:"claim_token,omitempty"` // Guest token whose tasks move to the account
+}
+
+// LoginIdentifier returns the username or email to log in with. Older clients
+// send it in the username field, or only an email.
+func (i *UserInput) LoginIdentifier() string {
+	switch {
+	case i.Identifier != "":
+		return i.Identifier
+	case i.Username != "":
+		return i.Username
+	default:
+		return i.Email
+	}
 }
 
 // AuthResponse represents the authentication response with token
 type AuthResponse struct {
-	Token string `json:"token"`
-	User  User   `json:"user"`
+	Token        string `json:"token,omitempty"` // Omitted when the session is only kept in a cookie
+	CSRFToken    string `json:"csrf_token,omitempty"`
+	User         User   `json:"user"`
+	ClaimedTasks int64  `json:"claimed_tasks,omitempty"`
+	ClaimError   string `json:"claim_error,omitempty"`
+}
+
+// UserPage is a page of users returned by admin searches
+type UserPage struct {
+	Users []User `json:"users"`
+	Total int64  `json:"total"`
+	Page  int    `json:"page"`
+	Limit int    `json:"limit"`
+}
+
+// PasswordResetInput represents an admin password reset request
+type PasswordResetInput struct {
+	Password string `json:"password"` // Optional; a temporary password is generated when empty
+}
+
+// PasswordResetResponse is returned after an admin password reset
+type PasswordResetResponse struct {
+	User              User   `json:"user"`
+	TemporaryPassword string `json:"temporary_password,omitempty"`
+}
+
+// ProfileInput represents a profile update; omitted fields are left unchanged
+type ProfileInput struct {
+	Username        *string `json:"username"`
+	Email           *string `json:"email"`
+	CurrentPassword string  `json:"current_password"` // Required to change the email
+}
+
+// PasswordChangeInput represents a password change by the user
+type PasswordChangeInput struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+// AccountDeletionInput confirms an account deletion with the user's password
+type AccountDeletionInput struct {
+	Password string `json:"password"`
 }
@@ -0,0 +1,100 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/bongo/golang-learnings/models"
+	"gorm.io/gorm"
+)
+
+// Pagination limits for admin listings
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
+
+type AdminService struct {
+	db *gorm.DB
+}
+
+func NewAdminService(db *gorm.DB) *AdminService {
+	return &AdminService{
+		db: db,
+	}
+}
+
+// ListUsers returns a page of users, optionally filtered by a username or email search
+func (s *AdminService) ListUsers(ctx context.Context, search string, page, limit int) (*models.UserPage, error) {
+	page, limit = normalizePage(page, limit)
+
+	query := s.db.WithContext(ctx).Model(&models.User{})
+	if search = strings.TrimSpace(search); search != "" {
+		pattern := containsPattern(models.NormalizeIdentifier(search))
+		query = query.Where(`username_normalized LIKE ? ESCAPE '\' OR email_normalized LIKE ? ESCAPE '\'`, pattern, pattern)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, err
+	}
+
+	users := []models.User{}
+	if err := query.Order("created_at desc").Offset((page - 1) * limit).Limit(limit).Find(&users).Error; err != nil {
+		return nil, err
+	}
+
+	return &models.UserPage{
+		Users: users,
+		Total: total,
+		Page:  page,
+		Limit: limit,
+	}, nil
+}
+
+// SetUserDisabled disables or re-enables a user account
+func (s *AdminService) SetUserDisabled(ctx context.Context, id int64, disabled bool, adminID int64) (*models.User, error) {
+	if id == adminID && disabled {
+		return nil, errors.New("admins cannot disable their own account")
+	}
+
+	var user models.User
+	if err := s.db.WithContext(ctx).First(&user, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+
+	if err := s.db.WithContext(ctx).Model(&user).Update("disabled", disabled).Error; err != nil {
+		return nil, err
+	}
+	user.Disabled = disabled
+
+	return &user, nil
+}
+
+// normalizePage applies defaults and bounds to pagination parameters
+func normalizePage(page, limit int) (int, int) {
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 {
+		limit = defaultPageLimit
+	}
+	if limit > maxPageLimit {
+		limit = maxPageLimit
+	}
+	return page, limit
+}
+
+// likeEscaper escapes the LIKE wildcards, and the escape character itself, so a
+// search for "a_b" or "50%" matches those characters literally
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// containsPattern returns a LIKE pattern, used with ESCAPE '\', that matches values
+// containing the search text
+func containsPattern(search string) string {
+	return "%" + likeEscaper.Replace(search) + "%"
+}
@@ -0,0 +1,173 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/bongo/golang-learnings/models"
+	"gorm.io/gorm"
+)
+
+// APIKeyPrefix marks a bearer credential as an API key rather than a JWT
+const APIKeyPrefix = "tm_"
+
+var (
+	ErrInvalidAPIKey  = errors.New("invalid API key")
+	ErrExpiredAPIKey  = errors.New("API key expired")
+	ErrAPIKeyNotFound = errors.New("API key not found")
+)
+
+type APIKeyService struct {
+	db *gorm.DB
+}
+
+func NewAPIKeyService(db *gorm.DB) *APIKeyService {
+	return &APIKeyService{
+		db: db,
+	}
+}
+
+// IsAPIKey reports whether a credential looks like one of our API keys
+func IsAPIKey(credential string) bool {
+	return strings.HasPrefix(credential, APIKeyPrefix)
+}
+
+// CreateAPIKey generates a new key for the user and returns it together with the plaintext key.
+// The key may only carry scopes that the creating credential holds itself.
+func (s *APIKeyService) CreateAPIKey(ctx context.Context, input *models.APIKeyInput, userID int64, allowedScopes []string) (*models.APIKey, string, error) {
+	name := strings.TrimSpace(input.Name)
+	if name == "" {
+		return nil, "", errors.New("API key name is required")
+	}
+	if input.ExpiresIn < 0 {
+		return nil, "", errors.New("expires_in_days must not be negative")
+	}
+
+	// Keys created without explicit scopes get the regular user scopes
+	scopes := models.ParseScopes(input.Scopes)
+	if len(scopes) == 0 {
+		scopes = models.DefaultUserScopes
+	}
+	if err := models.ValidateScopes(scopes); err != nil {
+		return nil, "", err
+	}
+	for _, scope := range scopes {
+		if !models.HasScope(allowedScopes, scope) {
+			return nil, "", errors.New("cannot grant scope not held by the current credential: " + scope)
+		}
+	}
+
+	prefix, err := randomHex(6)
+	if err != nil {
+		return nil, "", err
+	}
+	secret, err := randomHex(24)
+	if err != nil {
+		return nil, "", err
+	}
+
+	key := &models.APIKey{
+		ID:         time.Now().UnixNano(),
+		UserID:     userID,
+		Name:       name,
+		Prefix:     prefix,
+		SecretHash: hashSecret(secret),
+		Scopes:     strings.Join(scopes, " "),
+	}
+	if input.ExpiresIn > 0 {
+		expiresAt := time.Now().AddDate(0, 0, input.ExpiresIn)
+		key.ExpiresAt = &expiresAt
+	}
+
+	if err := s.db.WithContext(ctx).Create(key).Error; err != nil {
+		return nil, "", err
+	}
+
+	return key, APIKeyPrefix + prefix + "_" + secret, nil
+}
+
+// ListAPIKeys retrieves all API keys belonging to a user
+func (s *APIKeyService) ListAPIKeys(ctx context.Context, userID int64) ([]models.APIKey, error) {
+	var keys []models.APIKey
+	if err := s.db.WithContext(ctx).Where("user_id = ?", userID).Order("created_at desc").Find(&keys).Error; err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// RevokeAPIKey deletes one of the user's API keys
+func (s *APIKeyService) RevokeAPIKey(ctx context.Context, id int64, userID int64) error {
+	result := s.db.WithContext(ctx).Where("user_id = ?", userID).Delete(&models.APIKey{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+// ValidateAPIKey checks a plaintext key and returns the stored key record
+func (s *APIKeyService) ValidateAPIKey(ctx context.Context, rawKey string) (*models.APIKey, error) {
+	// Format: "tm_<prefix>_<secret>"
+	parts := strings.Split(strings.TrimPrefix(rawKey, APIKeyPrefix), "_")
+	if !IsAPIKey(rawKey) || len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, ErrInvalidAPIKey
+	}
+
+	var key models.APIKey
+	if err := s.db.WithContext(ctx).Where("prefix = ?", parts[0]).First(&key).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidAPIKey
+		}
+		return nil, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(key.SecretHash), []byte(hashSecret(parts[1]))) != 1 {
+		return nil, ErrInvalidAPIKey
+	}
+
+	now := time.Now()
+	if key.ExpiresAt != nil && now.After(*key.ExpiresAt) {
+		return nil, ErrExpiredAPIKey
+	}
+
+	// Record usage without touching the rest of the row
+	if err := s.db.WithContext(ctx).Model(&key).Update("last_used_at", now).Error; err != nil {
+		return nil, err
+	}
+	key.LastUsedAt = &now
+
+	return &key, nil
+}
+
+// KeyScopes returns the scopes granted to a key, falling back to the
+// regular user scopes for keys created before scopes existed
+func KeyScopes(key *models.APIKey) []string {
+	scopes := models.ParseScopes(key.Scopes)
+	if len(scopes) == 0 {
+		return models.DefaultUserScopes
+	}
+	return scopes
+}
+
+// randomHex returns n random bytes encoded as hex
+func randomHex(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// hashSecret hashes a high-entropy secret for storage
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
@@ -1,45 +1,115 @@
 package services
 
 import (
+	"context"
 	"errors"
-	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/bongo/golang-learnings/models"
-	"github.com/dgrijalva/jwt-go"
+	"github.com/golang-jwt/jwt/v5"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
 
+var (
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	ErrAccountDisabled    = errors.New("account is disabled")
+	ErrUserNotFound       = errors.New("user not found")
+	ErrTokenRevoked       = errors.New("token has been revoked")
+	ErrUsernameHasAt      = errors.New("username cannot contain @")
+)
+
+// Reasons a JWT is rejected
+var (
+	ErrTokenMalformed   = errors.New("token is malformed")
+	ErrTokenSignature   = errors.New("token signature is invalid")
+	ErrTokenExpired     = errors.New("token has expired")
+	ErrTokenNotYetValid = errors.New("token is not valid yet")
+	ErrTokenClaims      = errors.New("token claims are invalid")
+)
+
+// TokenClaims are the claims carried by login tokens
+type TokenClaims struct {
+	UserID   int64    `json:"user_id"`
+	Username string   `json:"username"`
+	Scopes   []string `json:"scopes,omitempty"`
+	Version  int      `json:"ver"`
+	jwt.RegisteredClaims
+}
+
+// TokenOptions control the registered claims of issued tokens and how strictly they are checked
+type TokenOptions struct {
+	Issuer   string
+	Audience string
+	TTL      time.Duration // Lifetime of new tokens
+	Leeway   time.Duration // Allowed clock skew when checking exp, nbf and iat
+}
+
+// DefaultTokenOptions are used until SetTokenOptions is called
+var DefaultTokenOptions = TokenOptions{
+	Issuer:   "golang-learnings",
+	Audience: "golang-learnings-api",
+	TTL:      24 * time.Hour,
+	Leeway:   30 * time.Second,
+}
+
+// Principal identifies the caller behind a validated token or API key
+type Principal struct {
+	UserID       int64
+	Scopes       []string
+	APIKeyID     int64 // Set when authenticated with an API key
+	TokenVersion int   // Session version of a JWT, see models.User.TokenVersion
+}
+
 type AuthService struct {
-	db        *gorm.DB
-	jwtSecret string
+	db             *gorm.DB
+	keys           *JWTKeySet
+	tokens         TokenOptions
+	passwordPolicy *PasswordPolicy
 }
 
-func NewAuthService(db *gorm.DB, jwtSecret string) *AuthService {
+func NewAuthService(db *gorm.DB, keys *JWTKeySet) *AuthService {
 	return &AuthService{
-		db:        db,
-		jwtSecret: jwtSecret,
+		db:     db,
+		keys:   keys,
+		tokens: DefaultTokenOptions,
 	}
 }
 
+// SetTokenOptions sets the issuer, audience and lifetimes used for login tokens
+func (s *AuthService) SetTokenOptions(options TokenOptions) {
+	s.tokens = options
+}
+
+// SetPasswordPolicy sets the policy enforced when passwords are chosen or changed
+func (s *AuthService) SetPasswordPolicy(policy *PasswordPolicy) {
+	s.passwordPolicy = policy
+}
+
 // GenerateToken creates a new JWT token for a user
 func (s *AuthService) GenerateToken(user *models.User) (string, error) {
-	// Set token expiration to 24 hours
-	expirationTime := time.Now().Add(24 * time.Hour)
+	now := time.Now()
 	
 	// Create the JWT claims
-	claims := jwt.MapClaims{
-		"user_id":  user.ID,
-		"username": user.Username,
-		"exp":      expirationTime.Unix(),
+	claims := &TokenClaims{
+		UserID:   user.ID,
+		Username: user.Username,
+		Scopes:   user.Scopes(),
+		Version:  user.TokenVersion,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    s.tokens.Issuer,
+			Subject:   strconv.FormatInt(user.ID, 10),
+			Audience:  jwt.ClaimStrings{s.tokens.Audience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(s.tokens.TTL)),
+		},
 	}
 	
-	// Create token with claims
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	
-	// Generate the signed token
-	tokenString, err := token.SignedString([]byte(s.jwtSecret))
+	// Generate the signed token with the current signing key
+	tokenString, err := s.keys.Sign(claims)
 	if err != nil {
 		return "", err
 	}
@@ -47,47 +117,65 @@ func (s *AuthService) GenerateToken(user *models.User) (string, error) {
 	return tokenString, nil
 }
 
-// ValidateToken validates a JWT token and returns the user ID
-func (s *AuthService) ValidateToken(tokenString string) (int64, error) {
-	// Parse the token
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		// Validate the signing method
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte(s.jwtSecret), nil
-	})
+// ValidateToken validates a JWT token and returns the principal it was issued to.
+// Errors are one of the ErrToken* values so callers can tell why a token was rejected.
+func (s *AuthService) ValidateToken(tokenString string) (*Principal, error) {
+	parser := jwt.NewParser(
+		jwt.WithValidMethods(s.keys.Algorithms()),
+		jwt.WithIssuer(s.tokens.Issuer),
+		jwt.WithAudience(s.tokens.Audience),
+		jwt.WithLeeway(s.tokens.Leeway),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	)
 	
+	claims := &TokenClaims{}
+	token, err := parser.ParseWithClaims(tokenString, claims, s.keys.Keyfunc)
 	if err != nil {
-		return 0, err
+		return nil, classifyTokenError(err)
+	}
+	if !token.Valid {
+		return nil, ErrTokenMalformed
 	}
 	
-	// Validate the token claims
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		// Check token expiration
-		if float64(time.Now().Unix()) > claims["exp"].(float64) {
-			return 0, errors.New("token expired")
-		}
-		
-		// Extract user ID
-		userId := int64(claims["user_id"].(float64))
-		return userId, nil
+	// The subject and user ID must agree
+	if claims.UserID == 0 || claims.Subject != strconv.FormatInt(claims.UserID, 10) {
+		return nil, ErrTokenClaims
 	}
 	
-	return 0, errors.New("invalid token")
+	// Tokens issued before scopes existed carry the regular user scopes
+	scopes := claims.Scopes
+	if scopes == nil {
+		scopes = models.DefaultUserScopes
+	}
+	
+	return &Principal{
+		UserID:       claims.UserID,
+		Scopes:       scopes,
+		TokenVersion: claims.Version,
+	}, nil
 }
 
 // RegisterUser creates a new user account
-func (s *AuthService) RegisterUser(input *models.UserInput) (*models.User, error) {
+func (s *AuthService) RegisterUser(ctx context.Context, input *models.UserInput) (*models.User, error) {
 	// Check if username or email already exists
+	if strings.Contains(input.Username, "@") {
+		return nil, ErrUsernameHasAt
+	}
+	
 	var existingUser models.User
-	result := s.db.Where("username = ? OR email = ?", input.Username, input.Email).First(&existingUser)
+	result := s.db.WithContext(ctx).Where("username_normalized = ? OR email_normalized = ?", models.NormalizeIdentifier(input.Username), models.NormalizeIdentifier(input.Email)).First(&existingUser)
 	if result.Error == nil {
 		return nil, errors.New("username or email already exists")
 	} else if !errors.Is(result.Error, gorm.ErrRecordNotFound) {
 		return nil, result.Error
 	}
 	
+	// Enforce the password policy
+	if err := s.passwordPolicy.Validate(input.Password, input.Username, input.Email); err != nil {
+		return nil, err
+	}
+	
 	// Hash the password
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(input.Password), bcrypt.DefaultCost)
 	if err != nil {
@@ -99,11 +187,12 @@ func (s *AuthService) RegisterUser(input *models.UserInput) (*models.User, error
 		Username: input.Username,
 		Email:    input.Email,
 		Password: string(hashedPassword),
+		Role:     models.RoleUser,
 		ID:       time.Now().UnixNano(),
 	}
 	
 	// Save user to database
-	if err := s.db.Create(user).Error; err != nil {
+	if err := s.db.WithContext(ctx).Create(user).Error; err != nil {
 		return nil, err
 	}
 	
@@ -111,21 +200,218 @@ func (s *AuthService) RegisterUser(input *models.UserInput) (*models.User, error
 }
 
 // LoginUser authenticates a user and returns the user if successful
-func (s *AuthService) LoginUser(input *models.UserInput) (*models.User, error) {
-	var user models.User
-	
-	// Find user by username or email
-	if err := s.db.Where("username = ? OR email = ?", input.Username, input.Email).First(&user).Error; err != nil {
+func (s *AuthService) LoginUser(ctx context.Context, input *models.UserInput) (*models.User, error) {
+	user, err := s.findByIdentifier(ctx, input.LoginIdentifier())
+	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, errors.New("invalid username or password")
+			return nil, ErrInvalidCredentials
 		}
 		return nil, err
 	}
 	
 	// Compare the stored hashed password with the provided password
 	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(input.Password)); err != nil {
-		return nil, errors.New("invalid username or password")
+		return nil, ErrInvalidCredentials
+	}
+	
+	// Disabled accounts cannot log in even with the right password
+	if user.Disabled {
+		return nil, ErrAccountDisabled
+	}
+	
+	return user, nil
+}
+
+// LookupUserID returns the ID of the user a login identifier names, or 0 if there is none
+func (s *AuthService) LookupUserID(ctx context.Context, identifier string) (int64, error) {
+	user, err := s.findByIdentifier(ctx, identifier)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return user.ID, nil
+}
+
+// findByIdentifier looks up a user by username or email
+func (s *AuthService) findByIdentifier(ctx context.Context, identifier string) (*models.User, error) {
+	identifier = models.NormalizeIdentifier(identifier)
+	if identifier == "" {
+		return nil, gorm.ErrRecordNotFound
+	}
+	
+	// Usernames can't contain "@", so an identifier with one is always an email
+	column := "username_normalized"
+	if strings.Contains(identifier, "@") {
+		column = "email_normalized"
+	}
+	
+	var user models.User
+	if err := s.db.WithContext(ctx).Where(column+" = ?", identifier).First(&user).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// ResolvePrincipal checks that the principal's account is still active and
+// drops the admin scopes from credentials of users who are no longer admins
+func (s *AuthService) ResolvePrincipal(ctx context.Context, principal *Principal) (*Principal, error) {
+	var user models.User
+	if err := s.db.WithContext(ctx).First(&user, principal.UserID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
 	}
 	
+	if user.Disabled {
+		return nil, ErrAccountDisabled
+	}
+	
+	// Password changes bump the token version, revoking older login tokens
+	if principal.APIKeyID == 0 && principal.TokenVersion != user.TokenVersion {
+		return nil, ErrTokenRevoked
+	}
+	
+	scopes := principal.Scopes
+	if !user.IsAdmin() {
+		scopes = make([]string, 0, len(principal.Scopes))
+		for _, scope := range principal.Scopes {
+			if !models.IsAdminScope(scope) {
+				scopes = append(scopes, scope)
+			}
+		}
+	}
+	
+	return &Principal{
+		UserID:       user.ID,
+		Scopes:       scopes,
+		APIKeyID:     principal.APIKeyID,
+		TokenVersion: principal.TokenVersion,
+	}, nil
+}
+
+// CheckPassword verifies a user's current password
+func (s *AuthService) CheckPassword(user *models.User, password string) error {
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
+		return ErrInvalidCredentials
+	}
+	return nil
+}
+
+// SetPassword replaces a user's password and revokes the user's existing login tokens
+func (s *AuthService) SetPassword(ctx context.Context, userID int64, password string) (*models.User, error) {
+	if password == "" {
+		return nil, errors.New("password is required")
+	}
+	
+	var user models.User
+	if err := s.db.WithContext(ctx).First(&user, userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+	
+	if err := s.passwordPolicy.Validate(password, user.Username, user.Email); err != nil {
+		return nil, err
+	}
+	
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+	
+	err = s.db.WithContext(ctx).Model(&user).Updates(map[string]interface{}{
+		"password":      string(hashedPassword),
+		"token_version": gorm.Expr("token_version + 1"),
+	}).Error
+	if err != nil {
+		return nil, err
+	}
+	user.Password = string(hashedPassword)
+	user.TokenVersion++
+	
 	return &user, nil
 }
+
+// ResetPassword sets a new password for a user on behalf of an admin. When no
+// password is given a temporary one is generated and returned.
+func (s *AuthService) ResetPassword(ctx context.Context, userID int64, password string) (*models.User, string, error) {
+	temporary := ""
+	if password == "" {
+		generated, err := s.passwordPolicy.GeneratePassword()
+		if err != nil {
+			return nil, "", err
+		}
+		temporary = generated
+		password = generated
+	}
+	
+	user, err := s.SetPassword(ctx, userID, password)
+	if err != nil {
+		return nil, "", err
+	}
+	
+	return user, temporary, nil
+}
+
+// BootstrapAdmin makes sure at least one admin exists. If there is none yet, a new
+// admin account is created. Existing users are never promoted: nobody has proven they
+// own the address they registered with, so whoever signed up first with the
+// operator's email would get the account.
+func (s *AuthService) BootstrapAdmin(ctx context.Context, username, email, password string) (*models.User, error) {
+	var admin models.User
+	err := s.db.WithContext(ctx).Where("role = ?", models.RoleAdmin).First(&admin).Error
+	if err == nil {
+		// An admin already exists, nothing to do
+		return nil, nil
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	
+	var existing models.User
+	err = s.db.WithContext(ctx).Where("username_normalized = ? OR email_normalized = ?", models.NormalizeIdentifier(username), models.NormalizeIdentifier(email)).First(&existing).Error
+	if err == nil {
+		return nil, errors.New("a user with the admin username or email already exists; choose another one for the first admin")
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	
+	if username == "" || password == "" {
+		return nil, errors.New("admin username and password are required to create the first admin")
+	}
+	
+	created, err := s.RegisterUser(ctx, &models.UserInput{
+		Username: username,
+		Email:    email,
+		Password: password,
+	})
+	if err != nil {
+		return nil, err
+	}
+	
+	if err := s.db.WithContext(ctx).Model(created).Update("role", models.RoleAdmin).Error; err != nil {
+		return nil, err
+	}
+	created.Role = models.RoleAdmin
+	
+	return created, nil
+}
+
+// classifyTokenError maps a parser error to one of the ErrToken* values
+func classifyTokenError(err error) error {
+	switch {
+	case errors.Is(err, jwt.ErrTokenExpired):
+		return ErrTokenExpired
+	case errors.Is(err, jwt.ErrTokenNotValidYet), errors.Is(err, jwt.ErrTokenUsedBeforeIssued):
+		return ErrTokenNotYetValid
+	case errors.Is(err, jwt.ErrTokenSignatureInvalid), errors.Is(err, jwt.ErrTokenUnverifiable):
+		return ErrTokenSignature
+	case errors.Is(err, jwt.ErrTokenInvalidClaims):
+		return ErrTokenClaims
+	default:
+		return ErrTokenMalformed
+	}
+}
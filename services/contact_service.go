@@ -1,49 +1,416 @@
 package services
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"net/mail"
 	"strings"
 	"time"
 
+	"github.com/bongo/golang-learnings/logging"
 	"github.com/bongo/golang-learnings/models"
 	"gorm.io/gorm"
 )
 
+var (
+	ErrContactNotFound = errors.New("contact message not found")
+	ErrContactNoEmail  = errors.New("contact message has no email address to reply to")
+	ErrInvalidAssignee = errors.New("contact messages can only be assigned to active admins")
+	ErrReplyNotSent    = errors.New("failed to send reply")
+)
+
+// defaultReplySubject is used for replies sent without a subject
+const defaultReplySubject = "Re: your message"
+
 type ContactService struct {
-	db *gorm.DB
+	db          *gorm.DB
+	mailer      Mailer
+	templates   *EmailTemplates
+	spamGuard   *ContactSpamGuard
+	notifier    *Notifier
+	acknowledge bool // Email senders a confirmation
 }
 
-func NewContactService(db *gorm.DB) *ContactService {
+func NewContactService(db *gorm.DB, mailer Mailer, templates *EmailTemplates, spamGuard *ContactSpamGuard, notifier *Notifier) *ContactService {
 	return &ContactService{
-		db: db,
+		db:        db,
+		mailer:    mailer,
+		templates: templates,
+		spamGuard: spamGuard,
+		notifier:  notifier,
 	}
 }
 
-// SubmitContactForm validates and stores a contact form submission
-func (s *ContactService) SubmitContactForm(input *models.ContactInput) (*models.Contact, error) {
+// SetAcknowledgements turns confirmation emails to senders of new messages on or off
+func (s *ContactService) SetAcknowledgements(enabled bool) {
+	s.acknowledge = enabled
+}
+
+// FormToken returns a token for a freshly loaded contact form and how long it is valid
+func (s *ContactService) FormToken() (string, time.Duration) {
+	return s.spamGuard.IssueToken(), s.spamGuard.TokenTTL()
+}
+
+// SubmitContactForm validates, screens and stores a contact form submission from the
+// given client IP. Messages that look like spam are stored with the spam status.
+func (s *ContactService) SubmitContactForm(ctx context.Context, input *models.ContactInput, ip string) (*models.Contact, error) {
 	// Validate input
 	if err := validateContactInput(input); err != nil {
 		return nil, err
 	}
 
+	verdict, err := s.spamGuard.Check(ctx, input, ip)
+	if err != nil {
+		return nil, err
+	}
+
 	// Create contact entry
 	contact := &models.Contact{
 		Name:      input.Name,
 		Email:     input.Email,
 		Message:   input.Message,
+		Locale:    input.Locale,
+		Status:    models.ContactStatusNew,
 		ID:        time.Now().UnixNano(),
 		CreatedAt: time.Now(),
 	}
+	if verdict.Spam {
+		contact.Status = models.ContactStatusSpam
+		contact.SpamReason = verdict.Reason
+	}
 
 	// Save to database
-	if err := s.db.Create(contact).Error; err != nil {
+	if err := s.db.WithContext(ctx).Create(contact).Error; err != nil {
+		return nil, err
+	}
+
+	// Neither staff nor the supposed sender hear about spam
+	if contact.Status != models.ContactStatusSpam {
+		s.notifyStaff(ctx, contact)
+		if s.acknowledge {
+			s.sendAcknowledgement(ctx, contact)
+		}
+	}
+
+	return contact, nil
+}
+
+// notifyStaff queues notifications about a new contact message
+func (s *ContactService) notifyStaff(ctx context.Context, contact *models.Contact) {
+	msg, err := s.templates.Render(EmailContactNotification, "", map[string]interface{}{
+		"ID":      contact.ID,
+		"Name":    contact.Name,
+		"Email":   contact.Email,
+		"Message": contact.Message,
+	})
+	if err != nil {
+		logging.FromContext(ctx).Error("Error rendering contact notification", "error", err)
+		return
+	}
+
+	s.notifier.Notify(ctx, &Notification{
+		Event:   "contact.created",
+		Subject: msg.Subject,
+		Text:    msg.Body,
+		HTML:    msg.HTML,
+		Data: map[string]interface{}{
+			"id":         contact.ID,
+			"name":       contact.Name,
+			"email":      contact.Email,
+			"message":    contact.Message,
+			"created_at": contact.CreatedAt,
+		},
+	})
+}
+
+// sendAcknowledgement queues a confirmation email to the sender of a contact message.
+// It repeats nothing the sender typed, not even their name, so the form can't be used
+// to send arbitrary text to any address.
+func (s *ContactService) sendAcknowledgement(ctx context.Context, contact *models.Contact) {
+	msg, err := s.templates.Render(EmailContactAcknowledgement, contact.Locale, map[string]interface{}{})
+	if err != nil {
+		logging.FromContext(ctx).Error("Error rendering contact acknowledgement", "error", err)
+		return
+	}
+
+	s.notifier.Send(ctx, NewEmailChannel(s.mailer, []string{contact.Email}), &Notification{
+		Event:   "contact.acknowledgement",
+		Subject: msg.Subject,
+		Text:    msg.Body,
+		HTML:    msg.HTML,
+	})
+}
+
+// ListContacts returns a page of contact messages matching the filter, newest first
+func (s *ContactService) ListContacts(ctx context.Context, filter *models.ContactFilter) (*models.ContactPage, error) {
+	page, limit := normalizePage(filter.Page, filter.Limit)
+
+	query := s.db.WithContext(ctx).Model(&models.Contact{})
+	if search := strings.TrimSpace(filter.Search); search != "" {
+		pattern := containsPattern(strings.ToLower(search))
+		query = query.Where(`LOWER(name) LIKE ? ESCAPE '\' OR LOWER(email) LIKE ? ESCAPE '\' OR LOWER(message) LIKE ? ESCAPE '\'`, pattern, pattern, pattern)
+	}
+	if filter.Since != nil {
+		query = query.Where("created_at >= ?", *filter.Since)
+	}
+	if filter.Until != nil {
+		query = query.Where("created_at < ?", *filter.Until)
+	}
+	if filter.Status != "" {
+		query = query.Where("status = ?", filter.Status)
+	}
+	if filter.AssigneeID != nil {
+		if *filter.AssigneeID == 0 {
+			query = query.Where("assignee_id IS NULL")
+		} else {
+			query = query.Where("assignee_id = ?", *filter.AssigneeID)
+		}
+	}
+	if filter.Read != nil {
+		if *filter.Read {
+			query = query.Where("read_at IS NOT NULL")
+		} else {
+			query = query.Where("read_at IS NULL")
+		}
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, err
+	}
+
+	contacts := []models.Contact{}
+	if err := query.Order("created_at desc").Offset((page - 1) * limit).Limit(limit).Find(&contacts).Error; err != nil {
+		return nil, err
+	}
+
+	var unread int64
+	if err := s.db.WithContext(ctx).Model(&models.Contact{}).Where("read_at IS NULL").Count(&unread).Error; err != nil {
+		return nil, err
+	}
+
+	return &models.ContactPage{
+		Contacts: contacts,
+		Total:    total,
+		Unread:   unread,
+		Page:     page,
+		Limit:    limit,
+	}, nil
+}
+
+// GetContact returns a single contact message with its notes and replies
+func (s *ContactService) GetContact(ctx context.Context, id int64) (*models.Contact, error) {
+	oldestFirst := func(db *gorm.DB) *gorm.DB {
+		return db.Order("created_at")
+	}
+
+	var contact models.Contact
+	if err := s.db.WithContext(ctx).Preload("Notes", oldestFirst).Preload("Replies", oldestFirst).First(&contact, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrContactNotFound
+		}
+		return nil, err
+	}
+	return &contact, nil
+}
+
+// UpdateContact changes the status and/or assignee of a contact message
+func (s *ContactService) UpdateContact(ctx context.Context, id int64, input *models.ContactUpdateInput) (*models.Contact, error) {
+	contact, err := s.GetContact(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	updates := map[string]interface{}{}
+	if input.AssigneeID != nil {
+		if *input.AssigneeID == 0 {
+			updates["assignee_id"] = nil
+		} else {
+			if err := s.checkAssignee(ctx, *input.AssigneeID); err != nil {
+				return nil, err
+			}
+			updates["assignee_id"] = *input.AssigneeID
+		}
+		// Picking up a message means someone is handling it
+		if contact.Status == models.ContactStatusNew {
+			updates["status"] = models.ContactStatusOpen
+		}
+	}
+	if input.Status != nil {
+		if !models.IsValidContactStatus(*input.Status) {
+			return nil, fmt.Errorf("status must be one of: %s", strings.Join(models.ContactStatuses, ", "))
+		}
+		updates["status"] = *input.Status
+	}
+
+	if len(updates) == 0 {
+		return contact, nil
+	}
+	if err := s.db.WithContext(ctx).Model(contact).Updates(updates).Error; err != nil {
 		return nil, err
 	}
 
+	return s.GetContact(ctx, id)
+}
+
+// AddNote records an internal note on a contact message
+func (s *ContactService) AddNote(ctx context.Context, id, authorID int64, input *models.ContactNoteInput) (*models.ContactNote, error) {
+	body := strings.TrimSpace(input.Body)
+	if body == "" {
+		return nil, errors.New("note body is required")
+	}
+
+	contact, err := s.GetContact(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	note := &models.ContactNote{
+		ID:        time.Now().UnixNano(),
+		ContactID: contact.ID,
+		AuthorID:  authorID,
+		Body:      body,
+		CreatedAt: time.Now(),
+	}
+
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(note).Error; err != nil {
+			return err
+		}
+		if contact.Status == models.ContactStatusNew {
+			return tx.Model(contact).Update("status", models.ContactStatusOpen).Error
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return note, nil
+}
+
+// Reply emails the sender of a contact message and records the reply on it.
+// Nothing is recorded when the email can't be sent.
+func (s *ContactService) Reply(ctx context.Context, id, authorID int64, input *models.ContactReplyInput) (*models.ContactReply, error) {
+	body := strings.TrimSpace(input.Body)
+	if body == "" {
+		return nil, errors.New("reply body is required")
+	}
+	subject := strings.TrimSpace(input.Subject)
+	if subject == "" {
+		subject = defaultReplySubject
+	}
+
+	contact, err := s.GetContact(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if contact.Email == "" {
+		return nil, ErrContactNoEmail
+	}
+
+	msg, err := s.templates.Render(EmailContactReply, contact.Locale, map[string]interface{}{
+		"Subject":    subject,
+		"Name":       contact.Name,
+		"Body":       body,
+		"Message":    contact.Message,
+		"ReceivedAt": contact.CreatedAt,
+	})
+	if err != nil {
+		return nil, err
+	}
+	msg.To = []string{contact.Email}
+
+	if err := s.mailer.Send(msg); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrReplyNotSent, err)
+	}
+
+	reply := &models.ContactReply{
+		ID:        time.Now().UnixNano(),
+		ContactID: contact.ID,
+		AuthorID:  authorID,
+		Subject:   subject,
+		Body:      body,
+		CreatedAt: time.Now(),
+	}
+
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(reply).Error; err != nil {
+			return err
+		}
+
+		updates := map[string]interface{}{"status": models.ContactStatusReplied}
+		if contact.ReadAt == nil {
+			updates["read_at"] = time.Now()
+		}
+		return tx.Model(contact).Updates(updates).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reply was sent but could not be recorded: %w", err)
+	}
+
+	return reply, nil
+}
+
+// SetContactRead marks a contact message as read or unread
+func (s *ContactService) SetContactRead(ctx context.Context, id int64, read bool) (*models.Contact, error) {
+	contact, err := s.GetContact(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	// Marking an already read message again keeps the time it was first read
+	if read == (contact.ReadAt != nil) {
+		return contact, nil
+	}
+
+	var readAt *time.Time
+	if read {
+		now := time.Now()
+		readAt = &now
+	}
+	if err := s.db.WithContext(ctx).Model(contact).Update("read_at", readAt).Error; err != nil {
+		return nil, err
+	}
+	contact.ReadAt = readAt
+
 	return contact, nil
 }
 
+// DeleteContact permanently deletes a contact message with its notes and replies
+func (s *ContactService) DeleteContact(ctx context.Context, id int64) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Delete(&models.Contact{}, id)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrContactNotFound
+		}
+
+		if err := tx.Where("contact_id = ?", id).Delete(&models.ContactNote{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("contact_id = ?", id).Delete(&models.ContactReply{}).Error
+	})
+}
+
+// checkAssignee verifies that the user can be assigned contact messages
+func (s *ContactService) checkAssignee(ctx context.Context, userID int64) error {
+	var count int64
+	err := s.db.WithContext(ctx).Model(&models.User{}).
+		Where("id = ? AND role = ? AND disabled = ?", userID, models.RoleAdmin, false).
+		Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrInvalidAssignee
+	}
+	return nil
+}
+
 // validateContactInput validates contact form data
 func validateContactInput(input *models.ContactInput) error {
 	// Check if any required field is empty
@@ -68,5 +435,10 @@ func validateContactInput(input *models.ContactInput) error {
 		return errors.New("message must be at least 10 characters long")
 	}
 
+	// Locales are language tags such as "en" or "pt-BR"
+	if len(input.Locale) > 35 {
+		return errors.New("invalid locale")
+	}
+
 	return nil
 }
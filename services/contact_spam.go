@@ -0,0 +1,172 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bongo/golang-learnings/logging"
+	"github.com/bongo/golang-learnings/models"
+)
+
+var (
+	ErrInvalidFormToken = errors.New("the form has expired, please reload the page and try again")
+	// ErrSpamDropped is returned for submissions that are certainly automated. They are
+	// discarded, and callers should answer as if they had been accepted.
+	ErrSpamDropped = errors.New("contact submission dropped as spam")
+)
+
+// SpamVerdict is the outcome of a spam check
+type SpamVerdict struct {
+	Spam   bool
+	Reason string
+}
+
+// SpamClassifier decides whether a contact message looks like spam. Messages it flags
+// are kept, marked as spam, for admins to review.
+type SpamClassifier interface {
+	Classify(ctx context.Context, input *models.ContactInput) (*SpamVerdict, error)
+}
+
+// linkPattern matches URLs and bare www. addresses
+var linkPattern = regexp.MustCompile(`(?i)https?://|www\.`)
+
+// HeuristicClassifier flags messages with too many links or containing spam keywords
+type HeuristicClassifier struct {
+	maxLinks int
+	keywords []string
+}
+
+func NewHeuristicClassifier(maxLinks int, keywords []string) *HeuristicClassifier {
+	lowered := make([]string, 0, len(keywords))
+	for _, keyword := range keywords {
+		if keyword = strings.ToLower(strings.TrimSpace(keyword)); keyword != "" {
+			lowered = append(lowered, keyword)
+		}
+	}
+
+	return &HeuristicClassifier{
+		maxLinks: maxLinks,
+		keywords: lowered,
+	}
+}
+
+// Classify checks the message and the sender's name, where spammers like to put links too
+func (c *HeuristicClassifier) Classify(ctx context.Context, input *models.ContactInput) (*SpamVerdict, error) {
+	text := input.Name + "\n" + input.Message
+
+	if links := len(linkPattern.FindAllStringIndex(text, -1)); links > c.maxLinks {
+		return &SpamVerdict{Spam: true, Reason: fmt.Sprintf("contains %d links", links)}, nil
+	}
+
+	lowered := strings.ToLower(text)
+	for _, keyword := range c.keywords {
+		if strings.Contains(lowered, keyword) {
+			return &SpamVerdict{Spam: true, Reason: fmt.Sprintf("contains %q", keyword)}, nil
+		}
+	}
+
+	return &SpamVerdict{}, nil
+}
+
+// ContactSpamGuard screens contact form submissions: a hidden honeypot field, a signed
+// token recording when the form was loaded, rate limits per client IP and per sender
+// address, and a classifier for the content.
+type ContactSpamGuard struct {
+	secret       []byte
+	minFillTime  time.Duration
+	tokenTTL     time.Duration
+	ipLimiter    *RateLimiter
+	emailLimiter *RateLimiter
+	classifier   SpamClassifier
+}
+
+func NewContactSpamGuard(secret string, minFillTime, tokenTTL time.Duration, ipLimiter, emailLimiter *RateLimiter, classifier SpamClassifier) *ContactSpamGuard {
+	return &ContactSpamGuard{
+		secret:       []byte(secret),
+		minFillTime:  minFillTime,
+		tokenTTL:     tokenTTL,
+		ipLimiter:    ipLimiter,
+		emailLimiter: emailLimiter,
+		classifier:   classifier,
+	}
+}
+
+// IssueToken returns a signed token recording when the form was handed out
+func (g *ContactSpamGuard) IssueToken() string {
+	issued := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	return issued + "." + g.signature(issued)
+}
+
+// TokenTTL is how long a form token stays valid
+func (g *ContactSpamGuard) TokenTTL() time.Duration {
+	return g.tokenTTL
+}
+
+// Check screens a submission from the given client IP. It returns ErrSpamDropped for
+// honeypot hits, ErrInvalidFormToken, or a *RateLimitedError; otherwise the verdict
+// says whether the message should be flagged as spam.
+func (g *ContactSpamGuard) Check(ctx context.Context, input *models.ContactInput, ip string) (*SpamVerdict, error) {
+	if ok, retryAfter := g.ipLimiter.Allow("ip:" + ip); !ok {
+		return nil, &RateLimitedError{RetryAfter: retryAfter}
+	}
+
+	// People don't see the honeypot field, so only bots fill it in
+	if strings.TrimSpace(input.Website) != "" {
+		return nil, ErrSpamDropped
+	}
+
+	age, err := g.tokenAge(input.FormToken)
+	if err != nil {
+		return nil, err
+	}
+
+	if ok, retryAfter := g.emailLimiter.Allow("email:" + models.NormalizeIdentifier(input.Email)); !ok {
+		return nil, &RateLimitedError{RetryAfter: retryAfter}
+	}
+
+	if age < g.minFillTime {
+		return &SpamVerdict{Spam: true, Reason: fmt.Sprintf("form filled in %s", age.Round(time.Millisecond))}, nil
+	}
+
+	if g.classifier == nil {
+		return &SpamVerdict{}, nil
+	}
+	verdict, err := g.classifier.Classify(ctx, input)
+	if err != nil {
+		// A broken classifier shouldn't lose messages
+		logging.FromContext(ctx).Error("Error classifying contact message", "error", err)
+		return &SpamVerdict{}, nil
+	}
+	return verdict, nil
+}
+
+// tokenAge verifies a form token and returns how long ago it was issued
+func (g *ContactSpamGuard) tokenAge(token string) (time.Duration, error) {
+	issued, signature, found := strings.Cut(token, ".")
+	if !found || !hmac.Equal([]byte(signature), []byte(g.signature(issued))) {
+		return 0, ErrInvalidFormToken
+	}
+
+	millis, err := strconv.ParseInt(issued, 10, 64)
+	if err != nil {
+		return 0, ErrInvalidFormToken
+	}
+
+	age := time.Since(time.UnixMilli(millis))
+	if age < 0 || age > g.tokenTTL {
+		return 0, ErrInvalidFormToken
+	}
+	return age, nil
+}
+
+// signature computes the HMAC of a form token
+func (g *ContactSpamGuard) signature(issued string) string {
+	return base64.RawURLEncoding.EncodeToString(hmacFor(g.secret, purposeContactForm, issued))
+}
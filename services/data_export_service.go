@@ -0,0 +1,305 @@
+package services
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/hmac"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bongo/golang-learnings/logging"
+	"github.com/bongo/golang-learnings/models"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrExportNotFound = errors.New("export not found")
+	ErrExportExpired  = errors.New("export link is invalid or has expired")
+)
+
+// exportReadme describes the files in an export archive
+const exportReadme = `This archive contains the personal data we hold about you.
+
+profile.json     Your account details
+tasks.json       Your tasks
+api_keys.json    Your API keys (without secrets)
+identities.json  Accounts at identity providers linked for single sign-on
+
+Contact form messages are not included: anyone can send them from any address, so
+they can't be tied to your account. Contact us to request them.
+`
+
+// DataExportService builds downloadable archives of a user's personal data
+type DataExportService struct {
+	db      *gorm.DB
+	dir     string
+	linkTTL time.Duration
+	secret  []byte
+	baseURL string
+
+	wg sync.WaitGroup // Exports being generated
+}
+
+func NewDataExportService(db *gorm.DB, dir string, linkTTL time.Duration, secret, baseURL string) *DataExportService {
+	return &DataExportService{
+		db:      db,
+		dir:     dir,
+		linkTTL: linkTTL,
+		secret:  []byte(secret),
+		baseURL: strings.TrimRight(baseURL, "/"),
+	}
+}
+
+// RequestExport starts generating an export in the background. A pending export
+// for the same user is returned instead of starting another one.
+func (s *DataExportService) RequestExport(ctx context.Context, userID int64) (*models.DataExport, error) {
+	if err := s.PurgeExpired(ctx); err != nil {
+		logging.FromContext(ctx).Error("Error purging expired exports", "error", err)
+	}
+
+	var pending models.DataExport
+	err := s.db.WithContext(ctx).Where("user_id = ? AND status = ?", userID, models.ExportPending).First(&pending).Error
+	if err == nil {
+		return &pending, nil
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	export := &models.DataExport{
+		ID:     time.Now().UnixNano(),
+		UserID: userID,
+		Status: models.ExportPending,
+	}
+	if err := s.db.WithContext(ctx).Create(export).Error; err != nil {
+		return nil, err
+	}
+
+	// The export outlives the request but keeps its request ID in the logs
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.generate(context.WithoutCancel(ctx), export.ID, userID)
+	}()
+
+	return export, nil
+}
+
+// FailInterrupted marks exports left pending by a previous run as failed. Exports are
+// generated in the process that requested them, so one still pending at startup was
+// interrupted and would otherwise keep its user from requesting another.
+func (s *DataExportService) FailInterrupted(ctx context.Context) error {
+	result := s.db.WithContext(ctx).Model(&models.DataExport{}).Where("status = ?", models.ExportPending).Updates(map[string]interface{}{
+		"status":       models.ExportFailed,
+		"error":        "Export was interrupted, please request a new one",
+		"completed_at": time.Now(),
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		logging.FromContext(ctx).Warn("Marked interrupted data exports as failed", "count", result.RowsAffected)
+	}
+	return nil
+}
+
+// Wait blocks until the exports being generated are finished or the context ends
+func (s *DataExportService) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("data exports still being generated: %w", ctx.Err())
+	}
+}
+
+// GetExport returns one of the user's exports, with a download link once it's ready
+func (s *DataExportService) GetExport(ctx context.Context, id, userID int64) (*models.DataExport, error) {
+	var export models.DataExport
+	if err := s.db.WithContext(ctx).Where("user_id = ?", userID).First(&export, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrExportNotFound
+		}
+		return nil, err
+	}
+
+	if export.Status == models.ExportReady && export.ExpiresAt != nil && time.Now().Before(*export.ExpiresAt) {
+		export.DownloadURL = s.downloadURL(&export)
+	}
+
+	return &export, nil
+}
+
+// OpenDownload verifies a signed download link and returns the archive path
+func (s *DataExportService) OpenDownload(ctx context.Context, id int64, expires, signature string) (string, error) {
+	expiresUnix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > expiresUnix {
+		return "", ErrExportExpired
+	}
+	if !hmac.Equal([]byte(signature), []byte(s.sign(id, expiresUnix))) {
+		return "", ErrExportExpired
+	}
+
+	var export models.DataExport
+	if err := s.db.WithContext(ctx).First(&export, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", ErrExportNotFound
+		}
+		return "", err
+	}
+	if export.Status != models.ExportReady || export.ExpiresAt == nil || time.Now().After(*export.ExpiresAt) {
+		return "", ErrExportExpired
+	}
+
+	return export.FilePath, nil
+}
+
+// PurgeExpired deletes expired exports and their archives
+func (s *DataExportService) PurgeExpired(ctx context.Context) error {
+	var expired []models.DataExport
+	if err := s.db.WithContext(ctx).Where("expires_at < ?", time.Now()).Find(&expired).Error; err != nil {
+		return err
+	}
+
+	for _, export := range expired {
+		if export.FilePath != "" {
+			if err := os.Remove(export.FilePath); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		if err := s.db.WithContext(ctx).Delete(&models.DataExport{}, export.ID).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// generate builds the archive and records the outcome on the export
+func (s *DataExportService) generate(ctx context.Context, exportID, userID int64) {
+	path := filepath.Join(s.dir, fmt.Sprintf("export-%d.zip", exportID))
+
+	updates := map[string]interface{}{}
+	if err := s.writeArchive(ctx, path, userID); err != nil {
+		logging.FromContext(ctx).Error("Error generating data export", "export_id", exportID, "error", err)
+		os.Remove(path)
+		updates["status"] = models.ExportFailed
+		updates["error"] = "Failed to generate export"
+	} else {
+		updates["status"] = models.ExportReady
+		updates["file_path"] = path
+		updates["expires_at"] = time.Now().Add(s.linkTTL)
+	}
+	updates["completed_at"] = time.Now()
+
+	if err := s.db.WithContext(ctx).Model(&models.DataExport{}).Where("id = ?", exportID).Updates(updates).Error; err != nil {
+		logging.FromContext(ctx).Error("Error updating data export", "export_id", exportID, "error", err)
+	}
+}
+
+// writeArchive collects the user's data and writes it as a ZIP archive
+func (s *DataExportService) writeArchive(ctx context.Context, path string, userID int64) error {
+	var user models.User
+	if err := s.db.WithContext(ctx).First(&user, userID).Error; err != nil {
+		return err
+	}
+
+	var tasks []models.Task
+	if err := s.db.WithContext(ctx).Where("user_id = ?", userID).Order("created_at").Find(&tasks).Error; err != nil {
+		return err
+	}
+
+	var apiKeys []models.APIKey
+	if err := s.db.WithContext(ctx).Where("user_id = ?", userID).Order("created_at").Find(&apiKeys).Error; err != nil {
+		return err
+	}
+
+	var identities []models.Identity
+	if err := s.db.WithContext(ctx).Where("user_id = ?", userID).Order("created_at").Find(&identities).Error; err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	archive := zip.NewWriter(file)
+	files := []struct {
+		name string
+		data interface{}
+	}{
+		{"profile.json", user},
+		{"tasks.json", tasks},
+		{"api_keys.json", apiKeys},
+		{"identities.json", identities},
+	}
+	for _, f := range files {
+		if err := writeJSONEntry(archive, f.name, f.data); err != nil {
+			return err
+		}
+	}
+
+	readme, err := createEntry(archive, "README.txt")
+	if err != nil {
+		return err
+	}
+	if _, err := readme.Write([]byte(exportReadme)); err != nil {
+		return err
+	}
+
+	return archive.Close()
+}
+
+// createEntry adds a compressed file stamped with the current time to the archive
+func createEntry(archive *zip.Writer, name string) (io.Writer, error) {
+	return archive.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: time.Now(),
+	})
+}
+
+// writeJSONEntry adds a pretty-printed JSON file to the archive
+func writeJSONEntry(archive *zip.Writer, name string, data interface{}) error {
+	entry, err := createEntry(archive, name)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(entry)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(data)
+}
+
+// downloadURL builds the signed download link for a ready export
+func (s *DataExportService) downloadURL(export *models.DataExport) string {
+	expires := export.ExpiresAt.Unix()
+	query := url.Values{}
+	query.Set("expires", strconv.FormatInt(expires, 10))
+	query.Set("signature", s.sign(export.ID, expires))
+	return fmt.Sprintf("%s/api/exports/%d/download?%s", s.baseURL, export.ID, query.Encode())
+}
+
+// sign computes the download link signature for an export
+func (s *DataExportService) sign(id, expires int64) string {
+	return hex.EncodeToString(hmacFor(s.secret, purposeExportLink, fmt.Sprintf("%d:%d", id, expires)))
+}
@@ -0,0 +1,181 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"os"
+	"path/filepath"
+	"strings"
+	texttemplate "text/template"
+)
+
+// Emails the app sends. Each is a set of files in a locale directory:
+// <name>.subject.txt and <name>.txt, plus an optional <name>.html.
+const (
+	EmailContactAcknowledgement = "contact_acknowledgement"
+	EmailContactNotification    = "contact_notification"
+	EmailContactReply           = "contact_reply"
+	EmailVerification           = "email_verification"
+	EmailChangeNotice           = "email_change_notice"
+	EmailMagicLink              = "magic_link"
+)
+
+// emailTemplateNames must all exist in the default locale
+var emailTemplateNames = []string{
+	EmailContactAcknowledgement,
+	EmailContactNotification,
+	EmailContactReply,
+	EmailVerification,
+	EmailChangeNotice,
+	EmailMagicLink,
+}
+
+// emailTemplate is one email in one locale
+type emailTemplate struct {
+	subject *texttemplate.Template
+	text    *texttemplate.Template
+	html    *htmltemplate.Template // nil when there is no HTML version
+}
+
+// EmailTemplates renders the app's emails from per-locale template files. Each
+// subdirectory of the template directory is a locale (e.g. "en", "de", "pt-br").
+// Emails missing from a locale fall back to the default locale.
+type EmailTemplates struct {
+	defaultLocale string
+	locales       map[string]map[string]*emailTemplate
+}
+
+// LoadEmailTemplates parses every locale directory under dir
+func LoadEmailTemplates(dir, defaultLocale string) (*EmailTemplates, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read email templates: %w", err)
+	}
+
+	t := &EmailTemplates{
+		defaultLocale: normalizeLocale(defaultLocale),
+		locales:       make(map[string]map[string]*emailTemplate),
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		templates, err := loadLocaleTemplates(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		t.locales[normalizeLocale(entry.Name())] = templates
+	}
+
+	defaults, ok := t.locales[t.defaultLocale]
+	if !ok {
+		return nil, fmt.Errorf("no email templates for the default locale %q in %s", defaultLocale, dir)
+	}
+	for _, name := range emailTemplateNames {
+		if defaults[name] == nil {
+			return nil, fmt.Errorf("email template %q is missing for the default locale %q", name, defaultLocale)
+		}
+	}
+
+	return t, nil
+}
+
+// loadLocaleTemplates parses the templates of one locale directory
+func loadLocaleTemplates(dir string) (map[string]*emailTemplate, error) {
+	templates := make(map[string]*emailTemplate)
+	for _, name := range emailTemplateNames {
+		base := filepath.Join(dir, name)
+
+		subject, err := parseTextTemplate(base + ".subject.txt")
+		if err != nil {
+			return nil, err
+		}
+		text, err := parseTextTemplate(base + ".txt")
+		if err != nil {
+			return nil, err
+		}
+		if subject == nil && text == nil {
+			continue
+		}
+		if subject == nil || text == nil {
+			return nil, fmt.Errorf("email template %s needs both %s.subject.txt and %s.txt", base, name, name)
+		}
+
+		tmpl := &emailTemplate{subject: subject, text: text}
+		if _, err := os.Stat(base + ".html"); err == nil {
+			html, err := htmltemplate.ParseFiles(base + ".html")
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse email template: %w", err)
+			}
+			tmpl.html = html.Option("missingkey=error")
+		}
+		templates[name] = tmpl
+	}
+	return templates, nil
+}
+
+// parseTextTemplate parses a text template file, returning nil if it doesn't exist
+func parseTextTemplate(path string) (*texttemplate.Template, error) {
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+
+	tmpl, err := texttemplate.ParseFiles(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse email template: %w", err)
+	}
+	return tmpl.Option("missingkey=error"), nil
+}
+
+// Render builds the named email in the closest available locale. The caller sets the recipients.
+func (t *EmailTemplates) Render(name, locale string, data interface{}) (*Message, error) {
+	tmpl := t.lookup(name, locale)
+	if tmpl == nil {
+		return nil, fmt.Errorf("unknown email template %q", name)
+	}
+
+	var subject, text, html bytes.Buffer
+	if err := tmpl.subject.Execute(&subject, data); err != nil {
+		return nil, fmt.Errorf("failed to render email subject %q: %w", name, err)
+	}
+	if err := tmpl.text.Execute(&text, data); err != nil {
+		return nil, fmt.Errorf("failed to render email %q: %w", name, err)
+	}
+	if tmpl.html != nil {
+		if err := tmpl.html.Execute(&html, data); err != nil {
+			return nil, fmt.Errorf("failed to render HTML email %q: %w", name, err)
+		}
+	}
+
+	return &Message{
+		Subject: strings.TrimSpace(subject.String()),
+		Body:    text.String(),
+		HTML:    html.String(),
+	}, nil
+}
+
+// lookup finds a template in the locale, its base language ("pt" for "pt-br"),
+// then the default locale
+func (t *EmailTemplates) lookup(name, locale string) *emailTemplate {
+	locale = normalizeLocale(locale)
+	candidates := []string{locale}
+	if base, _, found := strings.Cut(locale, "-"); found {
+		candidates = append(candidates, base)
+	}
+	candidates = append(candidates, t.defaultLocale)
+
+	for _, candidate := range candidates {
+		if tmpl := t.locales[candidate][name]; tmpl != nil {
+			return tmpl
+		}
+	}
+	return nil
+}
+
+// normalizeLocale lowercases a locale and uses dashes ("en_GB" becomes "en-gb")
+func normalizeLocale(locale string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(locale), "_", "-"))
+}
@@ -0,0 +1,56 @@
+package services
+
+import (
+	"crypto/hmac"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+// ErrInvalidGuestToken is returned for guest tokens that are malformed or not signed by us
+var ErrInvalidGuestToken = errors.New("invalid guest token")
+
+// GuestSessionService issues and verifies signed guest identities so anonymous
+// visitors get their own isolated task list
+type GuestSessionService struct {
+	secret []byte
+}
+
+func NewGuestSessionService(secret string) *GuestSessionService {
+	return &GuestSessionService{
+		secret: []byte(secret),
+	}
+}
+
+// NewGuest creates a new guest identity and returns its ID and signed token
+func (s *GuestSessionService) NewGuest() (string, string, error) {
+	guestID, err := randomHex(16)
+	if err != nil {
+		return "", "", err
+	}
+	return guestID, s.Sign(guestID), nil
+}
+
+// Sign returns the signed token for a guest ID
+func (s *GuestSessionService) Sign(guestID string) string {
+	return guestID + "." + s.signature(guestID)
+}
+
+// Verify checks a signed token and returns the guest ID it carries
+func (s *GuestSessionService) Verify(token string) (string, error) {
+	guestID, signature, found := strings.Cut(token, ".")
+	if !found || guestID == "" {
+		return "", ErrInvalidGuestToken
+	}
+
+	if !hmac.Equal([]byte(signature), []byte(s.signature(guestID))) {
+		return "", ErrInvalidGuestToken
+	}
+
+	return guestID, nil
+}
+
+// signature computes the HMAC of a guest ID
+func (s *GuestSessionService) signature(guestID string) string {
+	return base64.RawURLEncoding.EncodeToString(hmacFor(s.secret, purposeGuestSession, guestID))
+}
@@ -0,0 +1,256 @@
+package services
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// JWTKey is a key used to sign or verify tokens
+type JWTKey struct {
+	ID         string // Sent as the "kid" header
+	Method     jwt.SigningMethod
+	signingKey interface{} // Private key or HMAC secret; nil for verification-only keys
+	verifyKey  interface{} // Public key or HMAC secret
+}
+
+// JWTKeySet holds the key new tokens are signed with and every key tokens may be verified with.
+// Keeping retired public keys in the set lets tokens signed before a rotation stay valid.
+type JWTKeySet struct {
+	signing      *JWTKey
+	verification map[string]*JWTKey
+}
+
+// NewHMACKeySet creates a key set that signs and verifies with a shared secret (HS256)
+func NewHMACKeySet(secret string) *JWTKeySet {
+	key := &JWTKey{
+		Method:     jwt.SigningMethodHS256,
+		signingKey: []byte(secret),
+		verifyKey:  []byte(secret),
+	}
+	return &JWTKeySet{
+		signing:      key,
+		verification: map[string]*JWTKey{key.ID: key},
+	}
+}
+
+// LoadJWTKeySet creates an RS256 or EdDSA key set from a PEM private key file. Additional
+// verification keys are given as key ID to PEM file, public or private.
+func LoadJWTKeySet(algorithm, privateKeyFile, keyID string, verificationKeyFiles map[string]string) (*JWTKeySet, error) {
+	signing, err := loadPrivateKey(algorithm, privateKeyFile, keyID)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := &JWTKeySet{
+		signing:      signing,
+		verification: map[string]*JWTKey{signing.ID: signing},
+	}
+
+	for id, path := range verificationKeyFiles {
+		key, err := loadVerificationKey(path, id)
+		if err != nil {
+			return nil, err
+		}
+		if _, exists := keys.verification[key.ID]; exists {
+			return nil, fmt.Errorf("duplicate JWT key ID: %s", key.ID)
+		}
+		keys.verification[key.ID] = key
+	}
+
+	return keys, nil
+}
+
+// Sign creates a signed token with the current signing key
+func (ks *JWTKeySet) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(ks.signing.Method, claims)
+	if ks.signing.ID != "" {
+		token.Header["kid"] = ks.signing.ID
+	}
+	return token.SignedString(ks.signing.signingKey)
+}
+
+// Algorithms lists the signing algorithms of all verification keys
+func (ks *JWTKeySet) Algorithms() []string {
+	var algorithms []string
+	for _, key := range ks.verification {
+		alg := key.Method.Alg()
+		if !containsString(algorithms, alg) {
+			algorithms = append(algorithms, alg)
+		}
+	}
+	return algorithms
+}
+
+// Keyfunc picks the verification key for a token by its "kid" header, making
+// sure the token uses the algorithm that belongs to the key
+func (ks *JWTKeySet) Keyfunc(token *jwt.Token) (interface{}, error) {
+	keyID, _ := token.Header["kid"].(string)
+
+	key, ok := ks.verification[keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key: %q", keyID)
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+
+	return key.verifyKey, nil
+}
+
+// JWK is a public key in JSON Web Key format
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid,omitempty"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n,omitempty"`   // RSA modulus
+	E         string `json:"e,omitempty"`   // RSA exponent
+	Curve     string `json:"crv,omitempty"` // OKP or EC curve
+	X         string `json:"x,omitempty"`   // OKP public key or EC x coordinate
+	Y         string `json:"y,omitempty"`   // EC y coordinate
+}
+
+// JWKS is a JSON Web Key Set
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public verification keys. Shared HMAC secrets are never published.
+func (ks *JWTKeySet) JWKS() JWKS {
+	set := JWKS{Keys: []JWK{}}
+	for _, key := range ks.verification {
+		switch publicKey := key.verifyKey.(type) {
+		case *rsa.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				KeyType:   "RSA",
+				KeyID:     key.ID,
+				Use:       "sig",
+				Algorithm: key.Method.Alg(),
+				N:         base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes()),
+				E:         base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				KeyType:   "OKP",
+				KeyID:     key.ID,
+				Use:       "sig",
+				Algorithm: key.Method.Alg(),
+				Curve:     "Ed25519",
+				X:         base64.RawURLEncoding.EncodeToString(publicKey),
+			})
+		}
+	}
+	return set
+}
+
+// loadPrivateKey reads a signing key and checks it matches the algorithm
+func loadPrivateKey(algorithm, path, keyID string) (*JWTKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, err := parsePrivateKey(block)
+	if err != nil {
+		return nil, fmt.Errorf("invalid private key in %s: %w", path, err)
+	}
+
+	key, err := newAsymmetricKey(parsed.(crypto.Signer).Public(), keyID)
+	if err != nil {
+		return nil, err
+	}
+	if key.Method.Alg() != algorithm {
+		return nil, fmt.Errorf("private key in %s is not a %s key", path, algorithm)
+	}
+	key.signingKey = parsed
+
+	return key, nil
+}
+
+// loadVerificationKey reads a public key, or the public half of a private key
+func loadVerificationKey(path, keyID string) (*JWTKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if publicKey, err := x509.ParsePKIXPublicKey(block.Bytes); err == nil {
+		return newAsymmetricKey(publicKey, keyID)
+	}
+	if privateKey, err := parsePrivateKey(block); err == nil {
+		return newAsymmetricKey(privateKey.(crypto.Signer).Public(), keyID)
+	}
+
+	return nil, fmt.Errorf("no usable key in %s", path)
+}
+
+// newAsymmetricKey wraps a public key, deriving a key ID from it when none is given
+func newAsymmetricKey(publicKey crypto.PublicKey, keyID string) (*JWTKey, error) {
+	key := &JWTKey{ID: keyID, verifyKey: publicKey}
+
+	switch publicKey.(type) {
+	case *rsa.PublicKey:
+		key.Method = jwt.SigningMethodRS256
+	case ed25519.PublicKey:
+		key.Method = jwt.SigningMethodEdDSA
+	default:
+		return nil, errors.New("unsupported key type, expected RSA or Ed25519")
+	}
+
+	if key.ID == "" {
+		der, err := x509.MarshalPKIXPublicKey(publicKey)
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(der)
+		key.ID = base64.RawURLEncoding.EncodeToString(sum[:12])
+	}
+
+	return key, nil
+}
+
+// parsePrivateKey parses PKCS#8 keys and, for RSA, PKCS#1 keys
+func parsePrivateKey(block *pem.Block) (interface{}, error) {
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		switch key.(type) {
+		case *rsa.PrivateKey, ed25519.PrivateKey:
+			return key, nil
+		}
+		return nil, errors.New("unsupported key type, expected RSA or Ed25519")
+	}
+	return x509.ParsePKCS1PrivateKey(block.Bytes)
+}
+
+// readPEM reads the first PEM block of a file
+func readPEM(path string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data in %s", path)
+	}
+	return block, nil
+}
+
+// containsString reports whether value is in the list
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,342 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bongo/golang-learnings/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// LoginThrottledError is returned when a login must wait before it may be retried
+type LoginThrottledError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LoginThrottledError) Error() string {
+	return fmt.Sprintf("too many failed login attempts, retry in %s", e.RetryAfter.Round(time.Second))
+}
+
+// LoginAttemptStore persists failed login attempts
+type LoginAttemptStore interface {
+	// Get returns the attempt record for a key, or nil if there is none
+	Get(ctx context.Context, key string) (*models.LoginAttempt, error)
+	// RecordFailure atomically counts a failure at now for a key. Failures that
+	// expired under the policy are forgotten first, and the key is locked once it
+	// reaches the policy's MaxFailures.
+	RecordFailure(ctx context.Context, key string, policy LoginThrottlePolicy, now time.Time) error
+	Delete(ctx context.Context, key string) error
+	// DeleteExpired removes the records whose last failure and lockout both ended before the cutoff
+	DeleteExpired(ctx context.Context, before time.Time) error
+}
+
+// LoginThrottlePolicy controls backoff and lockout for one kind of key
+type LoginThrottlePolicy struct {
+	MaxFailures     int           // Failures before the key is locked out
+	BaseDelay       time.Duration // Delay after the first failure, doubled with each further failure
+	MaxDelay        time.Duration // Upper bound for the backoff delay
+	LockoutDuration time.Duration // How long a key stays locked, also how long failures are remembered
+}
+
+// backoff returns the delay required after the given number of failures
+func (p LoginThrottlePolicy) backoff(failures int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < failures && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	return delay
+}
+
+// expired reports whether an attempt record's failures can be forgotten at now
+func (p LoginThrottlePolicy) expired(attempt *models.LoginAttempt, now time.Time) bool {
+	return !attempt.LockedUntil.After(now) && now.Sub(attempt.LastFailureAt) > p.LockoutDuration
+}
+
+// sweepInterval is how often expired attempt records are removed
+const sweepInterval = time.Minute
+
+// AccountResolver returns the ID of the user a login identifier names, or 0 if
+// there is none
+type AccountResolver func(ctx context.Context, identifier string) (int64, error)
+
+// LoginThrottler tracks failed logins per account and per client IP, applying
+// exponential backoff and a temporary lockout after too many failures
+type LoginThrottler struct {
+	store         LoginAttemptStore
+	resolve       AccountResolver
+	accountPolicy LoginThrottlePolicy
+	ipPolicy      LoginThrottlePolicy
+	now           func() time.Time
+
+	sweepMu   sync.Mutex
+	lastSweep time.Time
+}
+
+func NewLoginThrottler(store LoginAttemptStore, resolve AccountResolver, accountPolicy, ipPolicy LoginThrottlePolicy) *LoginThrottler {
+	return &LoginThrottler{
+		store:         store,
+		resolve:       resolve,
+		accountPolicy: accountPolicy,
+		ipPolicy:      ipPolicy,
+		now:           time.Now,
+	}
+}
+
+// Check returns a *LoginThrottledError if the account or IP must wait before trying again
+func (t *LoginThrottler) Check(ctx context.Context, identifier, ip string) error {
+	now := t.now()
+
+	targets, err := t.targets(ctx, identifier, ip)
+	if err != nil {
+		return err
+	}
+
+	var wait time.Duration
+	for _, target := range targets {
+		attempt, err := t.load(ctx, target.key, target.policy, now)
+		if err != nil {
+			return err
+		}
+		if attempt == nil {
+			continue
+		}
+
+		if attempt.LockedUntil.After(now) && attempt.LockedUntil.Sub(now) > wait {
+			wait = attempt.LockedUntil.Sub(now)
+		}
+		if next := attempt.LastFailureAt.Add(target.policy.backoff(attempt.Failures)); next.After(now) && next.Sub(now) > wait {
+			wait = next.Sub(now)
+		}
+	}
+
+	if wait > 0 {
+		return &LoginThrottledError{RetryAfter: wait}
+	}
+	return nil
+}
+
+// RecordFailure counts a failed login for the account and IP
+func (t *LoginThrottler) RecordFailure(ctx context.Context, identifier, ip string) error {
+	now := t.now()
+
+	targets, err := t.targets(ctx, identifier, ip)
+	if err != nil {
+		return err
+	}
+	for _, target := range targets {
+		if err := t.store.RecordFailure(ctx, target.key, target.policy, now); err != nil {
+			return err
+		}
+	}
+
+	return t.sweep(ctx, now)
+}
+
+// Reset clears the failures of a user's account after a successful login. The IP
+// counter is kept so a valid login cannot be used to reset it.
+func (t *LoginThrottler) Reset(ctx context.Context, userID int64) error {
+	return t.store.Delete(ctx, userKey(userID))
+}
+
+// sweep removes expired attempt records, at most once per sweepInterval
+func (t *LoginThrottler) sweep(ctx context.Context, now time.Time) error {
+	t.sweepMu.Lock()
+	if now.Sub(t.lastSweep) < sweepInterval {
+		t.sweepMu.Unlock()
+		return nil
+	}
+	t.lastSweep = now
+	t.sweepMu.Unlock()
+
+	window := t.accountPolicy.LockoutDuration
+	if t.ipPolicy.LockoutDuration > window {
+		window = t.ipPolicy.LockoutDuration
+	}
+	return t.store.DeleteExpired(ctx, now.Add(-window))
+}
+
+type throttleTarget struct {
+	key    string
+	policy LoginThrottlePolicy
+}
+
+// targets lists the keys tracked for a login attempt. Identifiers naming an
+// account share the account's key, so its username and email count together.
+func (t *LoginThrottler) targets(ctx context.Context, identifier, ip string) ([]throttleTarget, error) {
+	targets := make([]throttleTarget, 0, 2)
+	if identifier != "" {
+		userID, err := t.resolve(ctx, identifier)
+		if err != nil {
+			return nil, err
+		}
+		key := accountKey(identifier)
+		if userID != 0 {
+			key = userKey(userID)
+		}
+		targets = append(targets, throttleTarget{key: key, policy: t.accountPolicy})
+	}
+	if ip != "" {
+		targets = append(targets, throttleTarget{key: "ip:" + ip, policy: t.ipPolicy})
+	}
+	return targets, nil
+}
+
+// load fetches an attempt record, ignoring failures once the lockout window has passed
+func (t *LoginThrottler) load(ctx context.Context, key string, policy LoginThrottlePolicy, now time.Time) (*models.LoginAttempt, error) {
+	attempt, err := t.store.Get(ctx, key)
+	if err != nil || attempt == nil {
+		return nil, err
+	}
+
+	if policy.expired(attempt, now) {
+		return nil, nil
+	}
+
+	return attempt, nil
+}
+
+// accountKey normalizes a login identifier that names no account into a throttle key
+func accountKey(identifier string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(identifier))
+}
+
+// userKey is the throttle key of a user's account
+func userKey(userID int64) string {
+	return "user:" + strconv.FormatInt(userID, 10)
+}
+
+// MemoryLoginAttemptStore keeps login attempts in memory. Attempts are lost on restart.
+type MemoryLoginAttemptStore struct {
+	mu       sync.Mutex
+	attempts map[string]models.LoginAttempt
+}
+
+func NewMemoryLoginAttemptStore() *MemoryLoginAttemptStore {
+	return &MemoryLoginAttemptStore{
+		attempts: make(map[string]models.LoginAttempt),
+	}
+}
+
+// Get returns a copy of the attempt record for a key
+func (s *MemoryLoginAttemptStore) Get(ctx context.Context, key string) (*models.LoginAttempt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	attempt, ok := s.attempts[key]
+	if !ok {
+		return nil, nil
+	}
+	return &attempt, nil
+}
+
+// RecordFailure counts a failure for a key while holding the store's lock
+func (s *MemoryLoginAttemptStore) RecordFailure(ctx context.Context, key string, policy LoginThrottlePolicy, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	attempt, ok := s.attempts[key]
+	if !ok || policy.expired(&attempt, now) {
+		attempt = models.LoginAttempt{Key: key}
+	}
+
+	attempt.Failures++
+	attempt.LastFailureAt = now
+	if attempt.Failures >= policy.MaxFailures {
+		attempt.LockedUntil = now.Add(policy.LockoutDuration)
+	}
+
+	s.attempts[key] = attempt
+	return nil
+}
+
+// Delete removes the attempt record for a key
+func (s *MemoryLoginAttemptStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.attempts, key)
+	return nil
+}
+
+// DeleteExpired removes the expired attempt records
+func (s *MemoryLoginAttemptStore) DeleteExpired(ctx context.Context, before time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, attempt := range s.attempts {
+		if attempt.LastFailureAt.Before(before) && attempt.LockedUntil.Before(before) {
+			delete(s.attempts, key)
+		}
+	}
+	return nil
+}
+
+// DBLoginAttemptStore keeps login attempts in the database so they survive restarts
+type DBLoginAttemptStore struct {
+	db *gorm.DB
+}
+
+func NewDBLoginAttemptStore(db *gorm.DB) *DBLoginAttemptStore {
+	return &DBLoginAttemptStore{
+		db: db,
+	}
+}
+
+// Get returns the attempt record for a key
+func (s *DBLoginAttemptStore) Get(ctx context.Context, key string) (*models.LoginAttempt, error) {
+	var attempt models.LoginAttempt
+	if err := s.db.WithContext(ctx).Where("key = ?", key).First(&attempt).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &attempt, nil
+}
+
+// RecordFailure counts a failure for a key in a transaction. The counter is
+// incremented in the database, so concurrent failures are never lost.
+func (s *DBLoginAttemptStore) RecordFailure(ctx context.Context, key string, policy LoginThrottlePolicy, now time.Time) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Forget expired failures
+		if err := tx.Where("key = ? AND locked_until <= ? AND last_failure_at < ?", key, now, now.Add(-policy.LockoutDuration)).
+			Delete(&models.LoginAttempt{}).Error; err != nil {
+			return err
+		}
+
+		attempt := models.LoginAttempt{Key: key, Failures: 1, LastFailureAt: now}
+		if err := tx.Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "key"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{
+				"failures":        gorm.Expr("failures + 1"),
+				"last_failure_at": now,
+			}),
+		}).Create(&attempt).Error; err != nil {
+			return err
+		}
+
+		return tx.Model(&models.LoginAttempt{}).
+			Where("key = ? AND failures >= ?", key, policy.MaxFailures).
+			Update("locked_until", now.Add(policy.LockoutDuration)).Error
+	})
+}
+
+// Delete removes the attempt record for a key
+func (s *DBLoginAttemptStore) Delete(ctx context.Context, key string) error {
+	return s.db.WithContext(ctx).Where("key = ?", key).Delete(&models.LoginAttempt{}).Error
+}
+
+// DeleteExpired removes the expired attempt records
+func (s *DBLoginAttemptStore) DeleteExpired(ctx context.Context, before time.Time) error {
+	return s.db.WithContext(ctx).Where("last_failure_at < ? AND locked_until < ?", before, before).
+		Delete(&models.LoginAttempt{}).Error
+}
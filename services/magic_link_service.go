@@ -0,0 +1,146 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/bongo/golang-learnings/models"
+	"gorm.io/gorm"
+)
+
+// ErrInvalidMagicLink is returned for sign-in links that are unknown, used or expired
+var ErrInvalidMagicLink = errors.New("sign-in link is invalid or has expired")
+
+// MagicLinkService signs users in through single-use links sent by email
+type MagicLinkService struct {
+	db        *gorm.DB
+	mailer    Mailer
+	templates *EmailTemplates
+	notifier  *Notifier
+	baseURL   string
+	ttl       time.Duration
+	limiter   *RateLimiter // Per email address
+}
+
+func NewMagicLinkService(db *gorm.DB, mailer Mailer, templates *EmailTemplates, notifier *Notifier, baseURL string, ttl time.Duration, limiter *RateLimiter) *MagicLinkService {
+	return &MagicLinkService{
+		db:        db,
+		mailer:    mailer,
+		templates: templates,
+		notifier:  notifier,
+		baseURL:   strings.TrimRight(baseURL, "/"),
+		ttl:       ttl,
+		limiter:   limiter,
+	}
+}
+
+// RequestLink emails a sign-in link to the user with the given address. To avoid
+// revealing which addresses have accounts, unknown and disabled accounts are
+// silently skipped and the email is sent in the background, so the response takes
+// as long either way; only rate limiting is reported, as a *RateLimitedError.
+func (s *MagicLinkService) RequestLink(ctx context.Context, email string) error {
+	email = models.NormalizeIdentifier(email)
+	if email == "" {
+		return errors.New("email is required")
+	}
+
+	if ok, retryAfter := s.limiter.Allow(email); !ok {
+		return &RateLimitedError{RetryAfter: retryAfter}
+	}
+
+	var user models.User
+	if err := s.db.WithContext(ctx).Where("email_normalized = ?", email).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+	if user.Disabled {
+		return nil
+	}
+
+	token, err := randomHex(32)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	// Links that can no longer be used are of no interest
+	if err := s.db.WithContext(ctx).Where("user_id = ? AND (used_at IS NOT NULL OR expires_at < ?)", user.ID, now).Delete(&models.MagicLinkToken{}).Error; err != nil {
+		return err
+	}
+	link := &models.MagicLinkToken{
+		ID:        now.UnixNano(),
+		UserID:    user.ID,
+		TokenHash: hashSecret(token),
+		ExpiresAt: now.Add(s.ttl),
+	}
+	if err := s.db.WithContext(ctx).Create(link).Error; err != nil {
+		return err
+	}
+
+	return s.sendLink(ctx, &user, token)
+}
+
+// ConsumeLink redeems a sign-in link and returns its user. A link works only once,
+// even when it is opened several times at the same moment.
+func (s *MagicLinkService) ConsumeLink(ctx context.Context, token string) (*models.User, error) {
+	if token == "" {
+		return nil, ErrInvalidMagicLink
+	}
+	hash := hashSecret(token)
+
+	now := time.Now()
+	result := s.db.WithContext(ctx).Model(&models.MagicLinkToken{}).
+		Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", hash, now).
+		Update("used_at", now)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrInvalidMagicLink
+	}
+
+	var link models.MagicLinkToken
+	if err := s.db.WithContext(ctx).Where("token_hash = ?", hash).First(&link).Error; err != nil {
+		return nil, err
+	}
+
+	var user models.User
+	if err := s.db.WithContext(ctx).First(&user, link.UserID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidMagicLink
+		}
+		return nil, err
+	}
+	if user.Disabled {
+		return nil, ErrAccountDisabled
+	}
+
+	return &user, nil
+}
+
+// sendLink queues the email with the sign-in link
+func (s *MagicLinkService) sendLink(ctx context.Context, user *models.User, token string) error {
+	link := s.baseURL + "/api/auth/magic-link/callback?token=" + url.QueryEscape(token)
+
+	msg, err := s.templates.Render(EmailMagicLink, "", map[string]interface{}{
+		"Username":         user.Username,
+		"Link":             link,
+		"ExpiresInMinutes": int(s.ttl.Minutes()),
+	})
+	if err != nil {
+		return err
+	}
+
+	s.notifier.Send(ctx, NewEmailChannel(s.mailer, []string{user.Email}), &Notification{
+		Event:   "auth.magic_link",
+		Subject: msg.Subject,
+		Text:    msg.Body,
+		HTML:    msg.HTML,
+	})
+	return nil
+}
@@ -0,0 +1,129 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/bongo/golang-learnings/logging"
+)
+
+// Message is an email to be sent
+type Message struct {
+	To      []string
+	Subject string
+	Body    string // Plain text
+	HTML    string // Optional HTML alternative to Body
+}
+
+// Mailer sends emails
+type Mailer interface {
+	Send(msg *Message) error
+}
+
+// LogMailer writes emails to the log instead of sending them. Useful in development,
+// it must not be used in production.
+type LogMailer struct {
+	redactor *logging.Redactor
+}
+
+func NewLogMailer(redactor *logging.Redactor) *LogMailer {
+	return &LogMailer{
+		redactor: redactor,
+	}
+}
+
+// Send logs the message. Only the plain text version is logged, with addresses and
+// the tokens in links redacted unless redaction is off.
+func (m *LogMailer) Send(msg *Message) error {
+	slog.Info("Email",
+		"to", m.redactor.String(strings.Join(msg.To, ", ")),
+		"subject", m.redactor.String(msg.Subject),
+		"body", m.redactor.String(msg.Body))
+	return nil
+}
+
+// SMTPMailer sends emails through an SMTP server
+type SMTPMailer struct {
+	host     string
+	port     string
+	username string
+	password string
+	from     string
+}
+
+func NewSMTPMailer(host, port, username, password, from string) *SMTPMailer {
+	return &SMTPMailer{
+		host:     host,
+		port:     port,
+		username: username,
+		password: password,
+		from:     from,
+	}
+}
+
+// Send delivers the message, authenticating when credentials are configured
+func (m *SMTPMailer) Send(msg *Message) error {
+	var auth smtp.Auth
+	if m.username != "" {
+		auth = smtp.PlainAuth("", m.username, m.password, m.host)
+	}
+
+	addr := net.JoinHostPort(m.host, m.port)
+	if err := smtp.SendMail(addr, auth, m.from, msg.To, m.format(msg)); err != nil {
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+	return nil
+}
+
+// format builds the raw message with headers. Messages with an HTML version are sent
+// as multipart/alternative so mail clients can pick either.
+func (m *SMTPMailer) format(msg *Message) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", m.from)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(msg.To, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", sanitizeHeader(msg.Subject)))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+
+	if msg.HTML == "" {
+		b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+		b.WriteString("\r\n")
+		b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+		return b.Bytes()
+	}
+
+	parts := multipart.NewWriter(&b)
+	fmt.Fprintf(&b, "Content-Type: multipart/alternative; boundary=%s\r\n", parts.Boundary())
+	b.WriteString("\r\n")
+	writeQuotedPrintablePart(parts, "text/plain; charset=UTF-8", msg.Body)
+	writeQuotedPrintablePart(parts, "text/html; charset=UTF-8", msg.HTML)
+	parts.Close()
+	return b.Bytes()
+}
+
+// writeQuotedPrintablePart adds a part whose content is quoted-printable encoded,
+// which keeps lines within SMTP limits whatever the content
+func writeQuotedPrintablePart(parts *multipart.Writer, contentType, content string) {
+	// Writes go to a bytes.Buffer and can't fail
+	part, _ := parts.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {contentType},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	encoder := quotedprintable.NewWriter(part)
+	encoder.Write([]byte(strings.ReplaceAll(content, "\n", "\r\n")))
+	encoder.Close()
+}
+
+// sanitizeHeader strips line breaks so values cannot inject extra headers
+func sanitizeHeader(value string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(value)
+}
@@ -0,0 +1,264 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bongo/golang-learnings/logging"
+)
+
+// Notification is an event staff should hear about
+type Notification struct {
+	Event   string      // e.g. "contact.created"
+	Subject string      // Short summary, used as the email subject
+	Text    string      // Human readable body
+	HTML    string      // Optional HTML version of Text for email
+	Data    interface{} // Payload for machine consumers such as webhooks
+}
+
+// NotificationChannel delivers notifications to one destination
+type NotificationChannel interface {
+	Name() string
+	Deliver(ctx context.Context, n *Notification) error
+}
+
+// EmailChannel emails notifications to a fixed list of recipients
+type EmailChannel struct {
+	mailer Mailer
+	to     []string
+}
+
+func NewEmailChannel(mailer Mailer, to []string) *EmailChannel {
+	return &EmailChannel{
+		mailer: mailer,
+		to:     to,
+	}
+}
+
+func (c *EmailChannel) Name() string {
+	return "email"
+}
+
+// Deliver sends the notification text as an email
+func (c *EmailChannel) Deliver(ctx context.Context, n *Notification) error {
+	return c.mailer.Send(&Message{
+		To:      c.to,
+		Subject: n.Subject,
+		Body:    n.Text,
+		HTML:    n.HTML,
+	})
+}
+
+// WebhookChannel posts notifications as JSON to a URL. Requests are signed with
+// HMAC-SHA256 over "<timestamp>.<body>" so receivers can verify where they came from.
+type WebhookChannel struct {
+	url    string
+	secret []byte
+	client *http.Client
+}
+
+func NewWebhookChannel(url, secret string, client *http.Client) *WebhookChannel {
+	return &WebhookChannel{
+		url:    url,
+		secret: []byte(secret),
+		client: client,
+	}
+}
+
+func (c *WebhookChannel) Name() string {
+	return "webhook"
+}
+
+// webhookPayload is the JSON body of webhook requests
+type webhookPayload struct {
+	Event     string      `json:"event"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+// Deliver posts the notification and fails unless the receiver answers with a 2xx status
+func (c *WebhookChannel) Deliver(ctx context.Context, n *Notification) error {
+	body, err := json.Marshal(webhookPayload{
+		Event:     n.Event,
+		CreatedAt: time.Now().UTC(),
+		Data:      n.Data,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Event", n.Event)
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", "sha256="+c.sign(timestamp, body))
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
+
+// sign computes the hex HMAC of a request body and its timestamp
+func (c *WebhookChannel) sign(timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, c.secret)
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// notificationJob is one notification on its way to one channel
+type notificationJob struct {
+	channel      NotificationChannel
+	notification *Notification
+	logger       *slog.Logger // Logger of the request that sent the notification
+}
+
+// Notifier delivers notifications in the background so callers are never slowed down.
+// Each channel gets its own job, retried with exponential backoff until it succeeds or
+// runs out of attempts. Jobs are kept in memory; Shutdown waits for queued ones but
+// gives up on those waiting to be retried.
+type Notifier struct {
+	channels    []NotificationChannel
+	maxAttempts int
+	backoff     time.Duration
+	timeout     time.Duration // Per delivery attempt
+
+	mu     sync.RWMutex
+	closed bool
+	queue  chan notificationJob
+	stop   chan struct{} // Closed by Shutdown to cut retry backoffs short
+	wg     sync.WaitGroup
+}
+
+func NewNotifier(channels []NotificationChannel, workers, queueSize, maxAttempts int, backoff time.Duration) *Notifier {
+	n := &Notifier{
+		channels:    channels,
+		maxAttempts: maxAttempts,
+		backoff:     backoff,
+		timeout:     30 * time.Second,
+		queue:       make(chan notificationJob, queueSize),
+		stop:        make(chan struct{}),
+	}
+
+	for i := 0; i < workers; i++ {
+		n.wg.Add(1)
+		go n.work()
+	}
+	return n
+}
+
+// Notify queues the notification for every configured channel. It never blocks: when
+// the queue is full or the notifier is shutting down the notification is dropped and logged.
+// Delivery problems are logged with the logger of ctx, but delivery outlives it.
+func (n *Notifier) Notify(ctx context.Context, notification *Notification) {
+	for _, channel := range n.channels {
+		n.Send(ctx, channel, notification)
+	}
+}
+
+// Send queues the notification for a single channel, such as an email to one person,
+// with the same delivery guarantees as Notify
+func (n *Notifier) Send(ctx context.Context, channel NotificationChannel, notification *Notification) {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	logger := logging.FromContext(ctx)
+	if n.closed {
+		logger.Warn("Notifier stopped, dropping notification", "channel", channel.Name(), "event", notification.Event)
+		return
+	}
+
+	select {
+	case n.queue <- notificationJob{channel: channel, notification: notification, logger: logger}:
+	default:
+		logger.Warn("Notification queue full, dropping notification", "channel", channel.Name(), "event", notification.Event)
+	}
+}
+
+// Shutdown stops accepting notifications and waits until the queued ones are delivered
+// or the context ends. Jobs waiting to be retried are given up rather than waited for.
+func (n *Notifier) Shutdown(ctx context.Context) error {
+	n.mu.Lock()
+	if !n.closed {
+		n.closed = true
+		close(n.queue)
+		close(n.stop)
+	}
+	n.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		n.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("notifications still pending: %w", ctx.Err())
+	}
+}
+
+// work delivers queued jobs until the queue is closed and empty
+func (n *Notifier) work() {
+	defer n.wg.Done()
+
+	for job := range n.queue {
+		n.deliver(job)
+	}
+}
+
+// deliver tries a job until it succeeds or runs out of attempts
+func (n *Notifier) deliver(job notificationJob) {
+	delay := n.backoff
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), n.timeout)
+		err := job.channel.Deliver(ctx, job.notification)
+		cancel()
+		if err == nil {
+			return
+		}
+
+		if attempt >= n.maxAttempts {
+			job.logger.Error("Giving up on notification",
+				"channel", job.channel.Name(), "event", job.notification.Event, "attempts", attempt, "error", err)
+			return
+		}
+
+		job.logger.Warn("Error delivering notification, retrying",
+			"channel", job.channel.Name(), "event", job.notification.Event,
+			"attempt", attempt, "max_attempts", n.maxAttempts, "retry_in", delay, "error", err)
+		select {
+		case <-time.After(delay):
+		case <-n.stop:
+			job.logger.Error("Notifier stopped, giving up on notification",
+				"channel", job.channel.Name(), "event", job.notification.Event, "attempts", attempt, "error", err)
+			return
+		}
+		delay *= 2
+	}
+}
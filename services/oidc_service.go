@@ -0,0 +1,567 @@
+package services
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bongo/golang-learnings/models"
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+)
+
+// OIDCFlowTTL is how long a user has to complete a login at the provider
+const OIDCFlowTTL = 10 * time.Minute
+
+// oidcKeyRefreshInterval limits how often an unknown key ID triggers a JWKS refetch
+const oidcKeyRefreshInterval = time.Minute
+
+// oidcAlgorithms are the ID token signing algorithms we accept
+var oidcAlgorithms = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+var (
+	ErrInvalidOIDCState    = errors.New("login session is invalid or has expired")
+	ErrOIDCEmailMissing    = errors.New("identity provider did not return an email address")
+	ErrOIDCAccountExists   = errors.New("an account with this email already exists, log in with your password")
+	ErrOIDCEmailUnverified = errors.New("identity provider has not verified your email address")
+)
+
+// OIDCConfig configures login through an external OpenID Connect provider
+type OIDCConfig struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string // Empty for public clients, which rely on PKCE alone
+	RedirectURL  string
+	Scopes       []string
+}
+
+// OIDCService implements the OpenID Connect authorization code flow with PKCE,
+// linking provider accounts to users and creating users on first login
+type OIDCService struct {
+	db     *gorm.DB
+	config OIDCConfig
+	secret []byte
+	client *http.Client
+
+	mu          sync.Mutex
+	provider    *oidcProvider
+	keys        map[string]interface{}
+	keysFetched time.Time
+}
+
+// oidcProvider is the part of the provider's discovery document we use
+type oidcProvider struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// oidcFlow is the per-login state kept in a signed cookie between redirect and callback
+type oidcFlow struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	Expires  int64  `json:"exp"`
+}
+
+// IDTokenClaims are the ID token claims we read
+type IDTokenClaims struct {
+	Nonce             string   `json:"nonce"`
+	Email             string   `json:"email"`
+	EmailVerified     oidcBool `json:"email_verified"`
+	PreferredUsername string   `json:"preferred_username"`
+	Name              string   `json:"name"`
+	AuthorizedParty   string   `json:"azp"`
+	jwt.RegisteredClaims
+}
+
+// oidcBool accepts both true and "true", as some providers send booleans as strings
+type oidcBool bool
+
+func (b *oidcBool) UnmarshalJSON(data []byte) error {
+	*b = oidcBool(strings.Trim(string(data), `"`) == "true")
+	return nil
+}
+
+// NewOIDCService creates the service. The secret signs the login state cookie and
+// client is used for all requests to the provider (http.DefaultClient when nil).
+func NewOIDCService(db *gorm.DB, config OIDCConfig, secret string, client *http.Client) *OIDCService {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	config.Issuer = strings.TrimSuffix(config.Issuer, "/")
+	return &OIDCService{
+		db:     db,
+		config: config,
+		secret: []byte(secret),
+		client: client,
+	}
+}
+
+// StartLogin returns the provider URL to send the user to, and the signed flow
+// state that must be handed back to CompleteLogin
+func (s *OIDCService) StartLogin(ctx context.Context) (string, string, error) {
+	provider, err := s.discover(ctx)
+	if err != nil {
+		return "", "", err
+	}
+
+	flow := oidcFlow{Expires: time.Now().Add(OIDCFlowTTL).Unix()}
+	for _, value := range []*string{&flow.State, &flow.Nonce, &flow.Verifier} {
+		if *value, err = randomHex(32); err != nil {
+			return "", "", err
+		}
+	}
+
+	challenge := sha256.Sum256([]byte(flow.Verifier))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {s.config.ClientID},
+		"redirect_uri":          {s.config.RedirectURL},
+		"scope":                 {strings.Join(s.config.Scopes, " ")},
+		"state":                 {flow.State},
+		"nonce":                 {flow.Nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+
+	separator := "?"
+	if strings.Contains(provider.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+
+	signed, err := s.signFlow(flow)
+	if err != nil {
+		return "", "", err
+	}
+	return provider.AuthorizationEndpoint + separator + query.Encode(), signed, nil
+}
+
+// CompleteLogin exchanges the authorization code, verifies the ID token and
+// returns the linked user, creating the user on first login
+func (s *OIDCService) CompleteLogin(ctx context.Context, code, state, signedFlow string) (*models.User, error) {
+	flow, err := s.verifyFlow(signedFlow)
+	if err != nil {
+		return nil, err
+	}
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(flow.State)) != 1 {
+		return nil, ErrInvalidOIDCState
+	}
+	if code == "" {
+		return nil, errors.New("authorization code is missing")
+	}
+
+	rawIDToken, err := s.exchangeCode(ctx, code, flow.Verifier)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, err := s.verifyIDToken(ctx, rawIDToken, flow.Nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.linkIdentity(ctx, claims)
+}
+
+// discover fetches and caches the provider's discovery document
+func (s *OIDCService) discover(ctx context.Context) (*oidcProvider, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.provider != nil {
+		return s.provider, nil
+	}
+
+	var provider oidcProvider
+	if err := s.getJSON(ctx, s.config.Issuer+"/.well-known/openid-configuration", &provider); err != nil {
+		return nil, fmt.Errorf("OIDC discovery failed: %w", err)
+	}
+	if strings.TrimSuffix(provider.Issuer, "/") != s.config.Issuer {
+		return nil, fmt.Errorf("OIDC discovery returned issuer %q, expected %q", provider.Issuer, s.config.Issuer)
+	}
+	if provider.AuthorizationEndpoint == "" || provider.TokenEndpoint == "" || provider.JWKSURI == "" {
+		return nil, errors.New("OIDC discovery document is missing endpoints")
+	}
+
+	s.provider = &provider
+	return s.provider, nil
+}
+
+// exchangeCode redeems an authorization code at the token endpoint and returns the ID token
+func (s *OIDCService) exchangeCode(ctx context.Context, code, verifier string) (string, error) {
+	provider, err := s.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {s.config.RedirectURL},
+		"code_verifier": {verifier},
+	}
+	if s.config.ClientSecret == "" {
+		form.Set("client_id", s.config.ClientID)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, provider.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if s.config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(s.config.ClientID), url.QueryEscape(s.config.ClientSecret))
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("OIDC token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body); err != nil {
+		return "", fmt.Errorf("OIDC token response is invalid: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("OIDC token request rejected: %s %s", body.Error, body.ErrorDescription)
+	}
+	if body.IDToken == "" {
+		return "", errors.New("OIDC token response has no id_token")
+	}
+
+	return body.IDToken, nil
+}
+
+// verifyIDToken checks the ID token's signature, issuer, audience, lifetime and nonce
+func (s *OIDCService) verifyIDToken(ctx context.Context, rawIDToken, nonce string) (*IDTokenClaims, error) {
+	parser := jwt.NewParser(
+		jwt.WithValidMethods(oidcAlgorithms),
+		jwt.WithIssuer(s.config.Issuer),
+		jwt.WithAudience(s.config.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(time.Minute),
+	)
+
+	claims := &IDTokenClaims{}
+	_, err := parser.ParseWithClaims(rawIDToken, claims, func(token *jwt.Token) (interface{}, error) {
+		keyID, _ := token.Header["kid"].(string)
+		return s.verificationKey(ctx, keyID)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID token: %w", err)
+	}
+
+	if claims.Subject == "" {
+		return nil, errors.New("invalid ID token: subject is missing")
+	}
+	if subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1 {
+		return nil, errors.New("invalid ID token: nonce does not match")
+	}
+	// Tokens for several audiences must name us as the authorized party
+	if len(claims.Audience) > 1 && claims.AuthorizedParty != s.config.ClientID {
+		return nil, errors.New("invalid ID token: unexpected authorized party")
+	}
+
+	return claims, nil
+}
+
+// verificationKey returns the provider key with the given ID, refetching the
+// provider's keys when the ID is unknown so provider key rotation is picked up
+func (s *OIDCService) verificationKey(ctx context.Context, keyID string) (interface{}, error) {
+	provider, err := s.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if key, ok := s.lookupKey(keyID); ok {
+		return key, nil
+	}
+	if time.Since(s.keysFetched) < oidcKeyRefreshInterval {
+		return nil, fmt.Errorf("unknown signing key: %q", keyID)
+	}
+
+	var set JWKS
+	if err := s.getJSON(ctx, provider.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("failed to fetch provider keys: %w", err)
+	}
+	keys := make(map[string]interface{}, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		if key, err := jwk.publicKey(); err == nil {
+			keys[jwk.KeyID] = key
+		}
+	}
+	s.keys = keys
+	s.keysFetched = time.Now()
+
+	if key, ok := s.lookupKey(keyID); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key: %q", keyID)
+}
+
+// lookupKey finds a cached key. Tokens without a key ID are accepted when the
+// provider publishes a single key. The caller must hold s.mu.
+func (s *OIDCService) lookupKey(keyID string) (interface{}, bool) {
+	if key, ok := s.keys[keyID]; ok {
+		return key, true
+	}
+	if keyID == "" && len(s.keys) == 1 {
+		for _, key := range s.keys {
+			return key, true
+		}
+	}
+	return nil, false
+}
+
+// linkIdentity returns the user linked to the ID token's subject. On first login the
+// identity is linked to the user with the same verified email, or to a new user.
+// Unverified emails are refused.
+func (s *OIDCService) linkIdentity(ctx context.Context, claims *IDTokenClaims) (*models.User, error) {
+	var user models.User
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+
+		var identity models.Identity
+		err := tx.Where("provider = ? AND subject = ?", s.config.Issuer, claims.Subject).First(&identity).Error
+		if err == nil {
+			if err := tx.First(&user, identity.UserID).Error; err != nil {
+				return err
+			}
+			return tx.Model(&identity).Updates(map[string]interface{}{"email": claims.Email, "last_login_at": now}).Error
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		if claims.Email == "" {
+			return ErrOIDCEmailMissing
+		}
+
+		err = tx.Where("email_normalized = ?", models.NormalizeIdentifier(claims.Email)).First(&user).Error
+		verified := bool(claims.EmailVerified)
+		switch {
+		case err == nil && !verified:
+			// Linking on an unverified address would let anyone take over the account
+			return ErrOIDCAccountExists
+		case errors.Is(err, gorm.ErrRecordNotFound) && !verified:
+			// A new account would hold on to an address its owner may want to register
+			return ErrOIDCEmailUnverified
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			created, err := s.provisionUser(tx, claims)
+			if err != nil {
+				return err
+			}
+			user = *created
+		case err != nil:
+			return err
+		}
+
+		return tx.Create(&models.Identity{
+			ID:          time.Now().UnixNano(),
+			UserID:      user.ID,
+			Provider:    s.config.Issuer,
+			Subject:     claims.Subject,
+			Email:       claims.Email,
+			LastLoginAt: now,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if user.Disabled {
+		return nil, ErrAccountDisabled
+	}
+	return &user, nil
+}
+
+// usernameInvalidChars matches characters not allowed in generated usernames
+var usernameInvalidChars = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+// provisionUser creates a user for a first-time login. The account has no password,
+// so it can only be used through the provider or a sign-in link until the user sets one.
+func (s *OIDCService) provisionUser(tx *gorm.DB, claims *IDTokenClaims) (*models.User, error) {
+	base := claims.PreferredUsername
+	if base == "" {
+		base, _, _ = strings.Cut(claims.Email, "@")
+	}
+	base = strings.Trim(usernameInvalidChars.ReplaceAllString(base, ""), ".-_")
+	if base == "" {
+		base = "user"
+	}
+
+	// Add a random suffix when the name is already taken
+	username := base
+	for attempt := 0; ; attempt++ {
+		var count int64
+		if err := tx.Model(&models.User{}).Where("username_normalized = ?", models.NormalizeIdentifier(username)).Count(&count).Error; err != nil {
+			return nil, err
+		}
+		if count == 0 {
+			break
+		}
+		if attempt == 5 {
+			return nil, errors.New("could not generate a unique username")
+		}
+		suffix, err := randomHex(2)
+		if err != nil {
+			return nil, err
+		}
+		username = base + "-" + suffix
+	}
+
+	user := &models.User{
+		ID:       time.Now().UnixNano(),
+		Username: username,
+		Email:    claims.Email,
+		Role:     models.RoleUser,
+	}
+	if err := tx.Create(user).Error; err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// getJSON fetches a JSON document from the provider
+func (s *OIDCService) getJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned %s", url, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+// signFlow encodes the login state as base64url(JSON).signature
+func (s *OIDCService) signFlow(flow oidcFlow) (string, error) {
+	payload, err := json.Marshal(flow)
+	if err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + s.flowSignature(encoded), nil
+}
+
+// verifyFlow checks the signature and expiry of the login state
+func (s *OIDCService) verifyFlow(signed string) (*oidcFlow, error) {
+	encoded, signature, found := strings.Cut(signed, ".")
+	if !found || !hmac.Equal([]byte(signature), []byte(s.flowSignature(encoded))) {
+		return nil, ErrInvalidOIDCState
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidOIDCState
+	}
+	var flow oidcFlow
+	if err := json.Unmarshal(payload, &flow); err != nil {
+		return nil, ErrInvalidOIDCState
+	}
+	if time.Now().Unix() > flow.Expires {
+		return nil, ErrInvalidOIDCState
+	}
+
+	return &flow, nil
+}
+
+// flowSignature computes the HMAC of an encoded login state
+func (s *OIDCService) flowSignature(encoded string) string {
+	return base64.RawURLEncoding.EncodeToString(hmacFor(s.secret, purposeOIDCState, encoded))
+}
+
+// publicKey converts a JWK into an RSA, EC or Ed25519 public key
+func (k JWK) publicKey() (interface{}, error) {
+	decode := func(s string) (*big.Int, error) {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil || len(b) == 0 {
+			return nil, errors.New("invalid key parameter")
+		}
+		return new(big.Int).SetBytes(b), nil
+	}
+
+	switch k.KeyType {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(k.E)
+		if err != nil || !e.IsInt64() {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve: %s", k.Curve)
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("EC point is not on the curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || k.Curve != "Ed25519" || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type: %s", k.KeyType)
+	}
+}
@@ -0,0 +1,368 @@
+package services
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bongo/golang-learnings/models"
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const testClientID = "task-manager"
+
+// mockIssuer is an OpenID Connect provider serving discovery, JWKS and token
+// endpoints. Codes are issued with issueCode and redeemed once at the token endpoint.
+type mockIssuer struct {
+	*httptest.Server
+
+	mu           sync.Mutex
+	keys         *JWTKeySet
+	jwksRequests int
+	codes        map[string]mockGrant
+}
+
+// mockGrant is what the provider remembers about an authorization code
+type mockGrant struct {
+	challenge string
+	claims    *IDTokenClaims
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	t.Helper()
+
+	issuer := &mockIssuer{codes: make(map[string]mockGrant)}
+	issuer.rotateKey(t, "key-1")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 issuer.URL,
+			"authorization_endpoint": issuer.URL + "/authorize",
+			"token_endpoint":         issuer.URL + "/token",
+			"jwks_uri":               issuer.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		issuer.mu.Lock()
+		defer issuer.mu.Unlock()
+		issuer.jwksRequests++
+		json.NewEncoder(w).Encode(issuer.keys.JWKS())
+	})
+	mux.HandleFunc("/token", issuer.token)
+
+	issuer.Server = httptest.NewServer(mux)
+	t.Cleanup(issuer.Close)
+	return issuer
+}
+
+// token redeems a code after checking the PKCE verifier against its challenge
+func (m *mockIssuer) token(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	grant, ok := m.codes[r.PostFormValue("code")]
+	delete(m.codes, r.PostFormValue("code"))
+	verifier := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(verifier[:]) != grant.challenge {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	idToken, err := m.keys.Sign(grant.claims)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"id_token": idToken, "token_type": "Bearer"})
+}
+
+// rotateKey replaces the provider's signing key with a new one
+func (m *mockIssuer) rotateKey(t *testing.T, keyID string) {
+	t.Helper()
+
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := newAsymmetricKey(public, keyID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key.signingKey = private
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.keys = &JWTKeySet{signing: key, verification: map[string]*JWTKey{keyID: key}}
+}
+
+// keyFetches returns how often the provider's keys were fetched
+func (m *mockIssuer) keyFetches() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.jwksRequests
+}
+
+// issueCode approves the login started at authURL and returns the code and state
+// the provider redirects back with. The ID token carries the login's nonce unless
+// the claims set one.
+func (m *mockIssuer) issueCode(t *testing.T, authURL string, claims *IDTokenClaims) (string, string) {
+	t.Helper()
+
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := parsed.Query()
+	if query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		t.Fatalf("login started without a PKCE challenge: %s", authURL)
+	}
+
+	if claims.Nonce == "" {
+		claims.Nonce = query.Get("nonce")
+	}
+	claims.Issuer = m.URL
+	claims.Audience = jwt.ClaimStrings{testClientID}
+	claims.IssuedAt = jwt.NewNumericDate(time.Now())
+	claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(time.Minute))
+
+	code, err := randomHex(8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.mu.Lock()
+	m.codes[code] = mockGrant{challenge: query.Get("code_challenge"), claims: claims}
+	m.mu.Unlock()
+
+	return code, query.Get("state")
+}
+
+func newTestOIDCService(t *testing.T, issuer *mockIssuer) (*OIDCService, *gorm.DB) {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&models.User{}, &models.Identity{}); err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, _ := db.DB()
+	t.Cleanup(func() { sqlDB.Close() })
+
+	service := NewOIDCService(db, OIDCConfig{
+		Issuer:      issuer.URL,
+		ClientID:    testClientID,
+		RedirectURL: "http://localhost/api/auth/oidc/callback",
+		Scopes:      []string{"openid", "email"},
+	}, "test-secret", issuer.Client())
+	return service, db
+}
+
+// login runs a complete login at the mock provider with the given ID token claims
+func login(t *testing.T, service *OIDCService, issuer *mockIssuer, claims *IDTokenClaims) (*models.User, error) {
+	t.Helper()
+
+	authURL, flow, err := service.StartLogin(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, state := issuer.issueCode(t, authURL, claims)
+	return service.CompleteLogin(context.Background(), code, state, flow)
+}
+
+func verifiedClaims(subject, email string) *IDTokenClaims {
+	return &IDTokenClaims{
+		Email:             email,
+		EmailVerified:     true,
+		PreferredUsername: "Jane Doe",
+		RegisteredClaims:  jwt.RegisteredClaims{Subject: subject},
+	}
+}
+
+func TestOIDCLoginProvisionsUser(t *testing.T) {
+	issuer := newMockIssuer(t)
+	service, db := newTestOIDCService(t, issuer)
+
+	user, err := login(t, service, issuer, verifiedClaims("sub-1", "jane@example.com"))
+	if err != nil {
+		t.Fatalf("first login failed: %v", err)
+	}
+	if user.Username != "JaneDoe" || user.Email != "jane@example.com" {
+		t.Errorf("provisioned user = %q <%s>, want JaneDoe <jane@example.com>", user.Username, user.Email)
+	}
+	if user.HasPassword() {
+		t.Error("provisioned user has a password")
+	}
+
+	again, err := login(t, service, issuer, verifiedClaims("sub-1", "jane@example.com"))
+	if err != nil {
+		t.Fatalf("second login failed: %v", err)
+	}
+	if again.ID != user.ID {
+		t.Errorf("second login returned user %d, want %d", again.ID, user.ID)
+	}
+
+	var identities int64
+	db.Model(&models.Identity{}).Where("user_id = ?", user.ID).Count(&identities)
+	if identities != 1 {
+		t.Errorf("user has %d identities, want 1", identities)
+	}
+}
+
+func TestOIDCLoginLinksExistingUser(t *testing.T) {
+	issuer := newMockIssuer(t)
+	service, db := newTestOIDCService(t, issuer)
+
+	existing := &models.User{ID: 1, Username: "jane", Email: "Jane@Example.com", Password: "hash", Role: models.RoleUser}
+	if err := db.Create(existing).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	unverified := verifiedClaims("sub-1", "jane@example.com")
+	unverified.EmailVerified = false
+	if _, err := login(t, service, issuer, unverified); !errors.Is(err, ErrOIDCAccountExists) {
+		t.Fatalf("login with unverified email = %v, want ErrOIDCAccountExists", err)
+	}
+
+	user, err := login(t, service, issuer, verifiedClaims("sub-1", "jane@example.com"))
+	if err != nil {
+		t.Fatalf("login with verified email failed: %v", err)
+	}
+	if user.ID != existing.ID {
+		t.Errorf("login returned user %d, want existing user %d", user.ID, existing.ID)
+	}
+}
+
+func TestOIDCLoginRefusesUnverifiedEmail(t *testing.T) {
+	issuer := newMockIssuer(t)
+	service, db := newTestOIDCService(t, issuer)
+
+	claims := verifiedClaims("sub-1", "jane@example.com")
+	claims.EmailVerified = false
+	if _, err := login(t, service, issuer, claims); !errors.Is(err, ErrOIDCEmailUnverified) {
+		t.Fatalf("login = %v, want ErrOIDCEmailUnverified", err)
+	}
+
+	var users int64
+	db.Model(&models.User{}).Count(&users)
+	if users != 0 {
+		t.Errorf("%d users created, want none", users)
+	}
+}
+
+func TestOIDCLoginVerifiesState(t *testing.T) {
+	issuer := newMockIssuer(t)
+	service, _ := newTestOIDCService(t, issuer)
+	ctx := context.Background()
+
+	authURL, flow, err := service.StartLogin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, state := issuer.issueCode(t, authURL, verifiedClaims("sub-1", "jane@example.com"))
+
+	tampered := []byte(flow)
+	tampered[0] ^= 1
+	tests := []struct {
+		name, state, flow string
+	}{
+		{"missing state", "", flow},
+		{"wrong state", state + "0", flow},
+		{"tampered flow", state, string(tampered)},
+		{"unsigned flow", state, strings.Split(flow, ".")[0]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := service.CompleteLogin(ctx, code, tt.state, tt.flow); !errors.Is(err, ErrInvalidOIDCState) {
+				t.Errorf("CompleteLogin = %v, want ErrInvalidOIDCState", err)
+			}
+		})
+	}
+
+	if _, err := service.CompleteLogin(ctx, code, state, flow); err != nil {
+		t.Errorf("CompleteLogin with the right state failed: %v", err)
+	}
+}
+
+func TestOIDCLoginSendsPKCEVerifier(t *testing.T) {
+	issuer := newMockIssuer(t)
+	service, _ := newTestOIDCService(t, issuer)
+	ctx := context.Background()
+
+	// A code issued for another login must not be redeemable with this login's verifier
+	authURL, _, err := service.StartLogin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, _ := issuer.issueCode(t, authURL, verifiedClaims("sub-1", "jane@example.com"))
+
+	otherURL, otherFlow, err := service.StartLogin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherState := issuer.issueCode(t, otherURL, verifiedClaims("sub-1", "jane@example.com"))
+
+	_, err = service.CompleteLogin(ctx, code, otherState, otherFlow)
+	if err == nil || !strings.Contains(err.Error(), "invalid_grant") {
+		t.Errorf("CompleteLogin with another login's code = %v, want invalid_grant", err)
+	}
+}
+
+func TestOIDCLoginRejectsNonceMismatch(t *testing.T) {
+	issuer := newMockIssuer(t)
+	service, _ := newTestOIDCService(t, issuer)
+
+	claims := verifiedClaims("sub-1", "jane@example.com")
+	claims.Nonce = "replayed"
+	_, err := login(t, service, issuer, claims)
+	if err == nil || !strings.Contains(err.Error(), "nonce") {
+		t.Errorf("login = %v, want nonce mismatch", err)
+	}
+}
+
+func TestOIDCLoginFollowsKeyRotation(t *testing.T) {
+	issuer := newMockIssuer(t)
+	service, _ := newTestOIDCService(t, issuer)
+
+	if _, err := login(t, service, issuer, verifiedClaims("sub-1", "jane@example.com")); err != nil {
+		t.Fatalf("login before rotation failed: %v", err)
+	}
+
+	// Keys were fetched moments ago, so an unknown key ID is refused without refetching
+	issuer.rotateKey(t, "key-2")
+	if _, err := login(t, service, issuer, verifiedClaims("sub-1", "jane@example.com")); err == nil {
+		t.Fatal("login with a key fetched too soon succeeded")
+	}
+	if fetches := issuer.keyFetches(); fetches != 1 {
+		t.Errorf("keys fetched %d times, want 1", fetches)
+	}
+
+	// Once the refresh interval has passed the new key is picked up
+	service.mu.Lock()
+	service.keysFetched = time.Now().Add(-oidcKeyRefreshInterval)
+	service.mu.Unlock()
+	if _, err := login(t, service, issuer, verifiedClaims("sub-1", "jane@example.com")); err != nil {
+		t.Fatalf("login after rotation failed: %v", err)
+	}
+	if fetches := issuer.keyFetches(); fetches != 2 {
+		t.Errorf("keys fetched %d times, want 2", fetches)
+	}
+}
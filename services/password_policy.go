@@ -0,0 +1,210 @@
+package services
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// PasswordPolicy describes the rules new passwords must follow
+type PasswordPolicy struct {
+	MinLength        int
+	RequireUpper     bool
+	RequireLower     bool
+	RequireDigit     bool
+	RequireSymbol    bool
+	DisallowIdentity bool                  // Reject passwords containing the username or email
+	Breached         *BreachedPasswordList // Optional list of known breached passwords
+}
+
+// Validate checks a password against the policy, reporting every rule it breaks
+func (p *PasswordPolicy) Validate(password, username, email string) error {
+	if p == nil {
+		return nil
+	}
+
+	var problems []string
+	if len([]rune(password)) < p.MinLength {
+		problems = append(problems, fmt.Sprintf("be at least %d characters long", p.MinLength))
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			hasSymbol = true
+		}
+	}
+	if p.RequireUpper && !hasUpper {
+		problems = append(problems, "contain an uppercase letter")
+	}
+	if p.RequireLower && !hasLower {
+		problems = append(problems, "contain a lowercase letter")
+	}
+	if p.RequireDigit && !hasDigit {
+		problems = append(problems, "contain a digit")
+	}
+	if p.RequireSymbol && !hasSymbol {
+		problems = append(problems, "contain a symbol")
+	}
+
+	if p.DisallowIdentity && containsIdentity(password, username, email) {
+		problems = append(problems, "not contain your username or email")
+	}
+
+	if len(problems) > 0 {
+		return errors.New("password must " + strings.Join(problems, ", "))
+	}
+
+	// Only consult the breach list for otherwise acceptable passwords
+	if p.Breached != nil && p.Breached.Contains(password) {
+		return errors.New("password has appeared in a data breach, please choose another one")
+	}
+
+	return nil
+}
+
+// containsIdentity reports whether the password contains the username, the
+// email address or the local part of the email address
+func containsIdentity(password, username, email string) bool {
+	lowered := strings.ToLower(password)
+
+	candidates := []string{username, email}
+	if local, _, found := strings.Cut(email, "@"); found {
+		candidates = append(candidates, local)
+	}
+
+	for _, candidate := range candidates {
+		// Very short identifiers would reject too many passwords
+		candidate = strings.ToLower(strings.TrimSpace(candidate))
+		if len(candidate) >= 3 && strings.Contains(lowered, candidate) {
+			return true
+		}
+	}
+	return false
+}
+
+// GeneratePassword creates a random password that satisfies the policy
+func (p *PasswordPolicy) GeneratePassword() (string, error) {
+	const (
+		upper   = "ABCDEFGHJKLMNPQRSTUVWXYZ"
+		lower   = "abcdefghijkmnopqrstuvwxyz"
+		digits  = "23456789"
+		symbols = "!@#$%^&*-_=+?"
+	)
+
+	length := 16
+	if p != nil && p.MinLength > length {
+		length = p.MinLength
+	}
+
+	// One character of every class, the rest from all of them
+	password := make([]byte, 0, length)
+	for _, class := range []string{upper, lower, digits, symbols} {
+		c, err := randomChar(class)
+		if err != nil {
+			return "", err
+		}
+		password = append(password, c)
+	}
+	for len(password) < length {
+		c, err := randomChar(upper + lower + digits + symbols)
+		if err != nil {
+			return "", err
+		}
+		password = append(password, c)
+	}
+
+	// Shuffle so the class order isn't predictable
+	for i := len(password) - 1; i > 0; i-- {
+		j, err := rand.Int(rand.Reader, big.NewInt(int64(i+1)))
+		if err != nil {
+			return "", err
+		}
+		password[i], password[j.Int64()] = password[j.Int64()], password[i]
+	}
+
+	return string(password), nil
+}
+
+// randomChar picks a random character from the alphabet
+func randomChar(alphabet string) (byte, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(len(alphabet))))
+	if err != nil {
+		return 0, err
+	}
+	return alphabet[n.Int64()], nil
+}
+
+// breachedPrefixLength is the number of hex characters used to bucket hashes,
+// as in the k-anonymity range API of Have I Been Pwned
+const breachedPrefixLength = 5
+
+// BreachedPasswordList is an offline list of SHA-1 hashes of breached passwords,
+// bucketed by hash prefix
+type BreachedPasswordList struct {
+	buckets map[string]map[string]struct{}
+}
+
+// LoadBreachedPasswordList reads a file of uppercase or lowercase SHA-1 hashes,
+// one per line, optionally followed by ":<count>" as in HIBP downloads
+func LoadBreachedPasswordList(path string) (*BreachedPasswordList, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	list := &BreachedPasswordList{buckets: make(map[string]map[string]struct{})}
+
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		hash, _, _ := strings.Cut(line, ":")
+		hash = strings.ToUpper(hash)
+		if _, err := hex.DecodeString(hash); err != nil || len(hash) != sha1.Size*2 {
+			return nil, fmt.Errorf("invalid SHA-1 hash on line %d of %s", lineNumber, path)
+		}
+
+		prefix, suffix := hash[:breachedPrefixLength], hash[breachedPrefixLength:]
+		if list.buckets[prefix] == nil {
+			list.buckets[prefix] = make(map[string]struct{})
+		}
+		list.buckets[prefix][suffix] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return list, nil
+}
+
+// Contains reports whether the password's hash is on the list
+func (l *BreachedPasswordList) Contains(password string) bool {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	bucket, ok := l.buckets[hash[:breachedPrefixLength]]
+	if !ok {
+		return false
+	}
+	_, found := bucket[hash[breachedPrefixLength:]]
+	return found
+}
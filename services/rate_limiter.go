@@ -0,0 +1,67 @@
+package services
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// RateLimitedError is returned when a request exceeds a rate limit
+type RateLimitedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitedError) Error() string {
+	return fmt.Sprintf("too many requests, retry in %s", e.RetryAfter.Round(time.Second))
+}
+
+// RateLimiter allows a number of events per key within a sliding time window.
+// State is kept in memory, so limits reset when the server restarts.
+type RateLimiter struct {
+	limit  int
+	window time.Duration
+
+	mu        sync.Mutex
+	events    map[string][]time.Time
+	lastSweep time.Time
+}
+
+func NewRateLimiter(limit int, window time.Duration) *RateLimiter {
+	return &RateLimiter{
+		limit:  limit,
+		window: window,
+		events: make(map[string][]time.Time),
+	}
+}
+
+// Allow records an event for the key if it is within the limit. Otherwise it
+// returns false and how long until the next event would be allowed.
+func (l *RateLimiter) Allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	cutoff := now.Add(-l.window)
+
+	// Forget idle keys now and then so the map doesn't grow forever
+	if now.Sub(l.lastSweep) > l.window {
+		for k, events := range l.events {
+			if !events[len(events)-1].After(cutoff) {
+				delete(l.events, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	events := l.events[key]
+	for len(events) > 0 && !events[0].After(cutoff) {
+		events = events[1:]
+	}
+	if len(events) >= l.limit {
+		l.events[key] = events
+		return false, events[0].Add(l.window).Sub(now)
+	}
+
+	l.events[key] = append(events, now)
+	return true, 0
+}
@@ -0,0 +1,31 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+)
+
+// Purposes the app signs values for. Each gets its own key derived from the secret,
+// so a value signed for one purpose is never accepted for another.
+const (
+	purposeGuestSession = "guest-session"
+	purposeCSRF         = "csrf"
+	purposeExportLink   = "export-link"
+	purposeOIDCState    = "oidc-state"
+	purposeContactForm  = "contact-form"
+)
+
+// hmacFor computes the HMAC-SHA256 of data with the key derived from the secret for
+// a purpose
+func hmacFor(secret []byte, purpose, data string) []byte {
+	mac := hmac.New(sha256.New, purposeKey(secret, purpose))
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// purposeKey derives the key for a purpose from the secret
+func purposeKey(secret []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("task-manager signing key: " + purpose))
+	return mac.Sum(nil)
+}
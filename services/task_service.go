@@ -1,6 +1,7 @@
 package services
 
 import (
+	"context"
 	"errors"
 	"time"
 
@@ -8,6 +9,13 @@ import (
 	"gorm.io/gorm"
 )
 
+var (
+	// ErrNoTaskOwner is returned when a task operation has neither a user nor a guest to scope it to
+	ErrNoTaskOwner = errors.New("task owner is required")
+	// ErrGuestTasksClaimed is returned when a guest's tasks already belong to another account
+	ErrGuestTasksClaimed = errors.New("guest tasks were already claimed by another account")
+)
+
 type TaskService struct {
 	db *gorm.DB
 }
@@ -18,13 +26,13 @@ func NewTaskService(db *gorm.DB) *TaskService {
 	}
 }
 
-// GetAllTasks retrieves all tasks for a user
-func (s *TaskService) GetAllTasks(userID int64) ([]models.Task, error) {
+// GetAllTasks retrieves all tasks for a user or guest
+func (s *TaskService) GetAllTasks(ctx context.Context, owner models.TaskOwner) ([]models.Task, error) {
 	var tasks []models.Task
 	
-	query := s.db
-	if userID > 0 {
-		query = query.Where("user_id = ?", userID)
+	query, err := s.ownedBy(ctx, owner)
+	if err != nil {
+		return nil, err
 	}
 	
 	if err := query.Find(&tasks).Error; err != nil {
@@ -35,12 +43,12 @@ func (s *TaskService) GetAllTasks(userID int64) ([]models.Task, error) {
 }
 
 // GetTaskByID retrieves a specific task
-func (s *TaskService) GetTaskByID(id int64, userID int64) (*models.Task, error) {
+func (s *TaskService) GetTaskByID(ctx context.Context, id int64, owner models.TaskOwner) (*models.Task, error) {
 	var task models.Task
 	
-	query := s.db
-	if userID > 0 {
-		query = query.Where("user_id = ?", userID)
+	query, err := s.ownedBy(ctx, owner)
+	if err != nil {
+		return nil, err
 	}
 	
 	if err := query.First(&task, id).Error; err != nil {
@@ -54,15 +62,22 @@ func (s *TaskService) GetTaskByID(id int64, userID int64) (*models.Task, error)
 }
 
 // CreateTask creates a new task
-func (s *TaskService) CreateTask(input *models.TaskInput, userID int64) (*models.Task, error) {
+func (s *TaskService) CreateTask(ctx context.Context, input *models.TaskInput, owner models.TaskOwner) (*models.Task, error) {
+	if owner.UserID <= 0 && owner.GuestID == "" {
+		return nil, ErrNoTaskOwner
+	}
+	
 	task := &models.Task{
 		Text:      input.Text,
 		Completed: input.Completed,
-		UserID:    userID,
+		UserID:    owner.UserID,
 		ID:        time.Now().UnixNano(),
 	}
+	if owner.UserID <= 0 {
+		task.GuestID = owner.GuestID
+	}
 	
-	if err := s.db.Create(task).Error; err != nil {
+	if err := s.db.WithContext(ctx).Create(task).Error; err != nil {
 		return nil, err
 	}
 	
@@ -70,19 +85,19 @@ func (s *TaskService) CreateTask(input *models.TaskInput, userID int64) (*models
 }
 
 // UpdateTask updates an existing task
-func (s *TaskService) UpdateTask(id int64, input *models.TaskInput, userID int64) (*models.Task, error) {
+func (s *TaskService) UpdateTask(ctx context.Context, id int64, input *models.TaskInput, owner models.TaskOwner) (*models.Task, error) {
 	// Get the existing task
 	var task models.Task
 	
-	query := s.db
-	if userID > 0 {
-		query = query.Where("user_id = ?", userID)
+	query, err := s.ownedBy(ctx, owner)
+	if err != nil {
+		return nil, err
 	}
 	
 	if err := query.First(&task, id).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			// Create new task if it doesn't exist
-			return s.CreateTask(input, userID)
+			return s.CreateTask(ctx, input, owner)
 		}
 		return nil, err
 	}
@@ -91,7 +106,7 @@ func (s *TaskService) UpdateTask(id int64, input *models.TaskInput, userID int64
 	task.Text = input.Text
 	task.Completed = input.Completed
 	
-	if err := s.db.Save(&task).Error; err != nil {
+	if err := s.db.WithContext(ctx).Save(&task).Error; err != nil {
 		return nil, err
 	}
 	
@@ -99,10 +114,10 @@ func (s *TaskService) UpdateTask(id int64, input *models.TaskInput, userID int64
 }
 
 // DeleteTask deletes a task
-func (s *TaskService) DeleteTask(id int64, userID int64) error {
-	query := s.db
-	if userID > 0 {
-		query = query.Where("user_id = ?", userID)
+func (s *TaskService) DeleteTask(ctx context.Context, id int64, owner models.TaskOwner) error {
+	query, err := s.ownedBy(ctx, owner)
+	if err != nil {
+		return err
 	}
 	
 	result := query.Delete(&models.Task{}, id)
@@ -117,3 +132,54 @@ func (s *TaskService) DeleteTask(id int64, userID int64) error {
 	
 	return nil
 }
+
+// ClaimGuestTasks transfers a guest session's tasks to a registered user and
+// returns how many tasks were claimed. Claiming twice into the same account is
+// a no-op; claiming tasks that another account already owns is rejected.
+func (s *TaskService) ClaimGuestTasks(ctx context.Context, guestID string, userID int64) (int64, error) {
+	if guestID == "" || userID <= 0 {
+		return 0, ErrNoTaskOwner
+	}
+	
+	var claimed int64
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Claimed tasks keep their guest ID so later claims can detect conflicts
+		result := tx.Model(&models.Task{}).
+			Where("guest_id = ? AND user_id = ?", guestID, 0).
+			Update("user_id", userID)
+		if result.Error != nil {
+			return result.Error
+		}
+		claimed = result.RowsAffected
+		
+		var conflicts int64
+		if err := tx.Model(&models.Task{}).
+			Where("guest_id = ? AND user_id NOT IN ?", guestID, []int64{0, userID}).
+			Count(&conflicts).Error; err != nil {
+			return err
+		}
+		if conflicts > 0 {
+			return ErrGuestTasksClaimed
+		}
+		
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	
+	return claimed, nil
+}
+
+// ownedBy scopes a query to the owner's tasks. Guests only see tasks that
+// have not been claimed by a registered user.
+func (s *TaskService) ownedBy(ctx context.Context, owner models.TaskOwner) (*gorm.DB, error) {
+	switch {
+	case owner.UserID > 0:
+		return s.db.WithContext(ctx).Where("user_id = ?", owner.UserID), nil
+	case owner.GuestID != "":
+		return s.db.WithContext(ctx).Where("user_id = ? AND guest_id = ?", 0, owner.GuestID), nil
+	default:
+		return nil, ErrNoTaskOwner
+	}
+}
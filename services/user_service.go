@@ -0,0 +1,303 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/bongo/golang-learnings/logging"
+	"github.com/bongo/golang-learnings/models"
+	"gorm.io/gorm"
+)
+
+// How long an email verification link stays valid
+const emailVerificationTTL = 24 * time.Hour
+
+// Account deletion modes for the user's tasks and submissions
+const (
+	DeletionModeDelete    = "delete"
+	DeletionModeAnonymize = "anonymize"
+)
+
+var ErrInvalidVerificationToken = errors.New("invalid or expired verification token")
+
+type UserService struct {
+	db          *gorm.DB
+	authService *AuthService
+	mailer      Mailer
+	templates   *EmailTemplates
+	baseURL     string
+}
+
+func NewUserService(db *gorm.DB, authService *AuthService, mailer Mailer, templates *EmailTemplates, baseURL string) *UserService {
+	return &UserService{
+		db:          db,
+		authService: authService,
+		mailer:      mailer,
+		templates:   templates,
+		baseURL:     strings.TrimRight(baseURL, "/"),
+	}
+}
+
+// GetUser retrieves a user by ID
+func (s *UserService) GetUser(ctx context.Context, id int64) (*models.User, error) {
+	var user models.User
+	if err := s.db.WithContext(ctx).First(&user, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+	return &user, nil
+}
+
+// UpdateProfile changes the username and starts an email change. The new email
+// only takes effect once confirmed through the link sent to it.
+func (s *UserService) UpdateProfile(ctx context.Context, userID int64, input *models.ProfileInput) (*models.User, error) {
+	user, err := s.GetUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if input.Username != nil {
+		username := strings.TrimSpace(*input.Username)
+		if username == "" {
+			return nil, errors.New("username cannot be empty")
+		}
+		if strings.Contains(username, "@") {
+			return nil, ErrUsernameHasAt
+		}
+		if username != user.Username {
+			if err := s.ensureAvailable(ctx, "username", username, userID); err != nil {
+				return nil, err
+			}
+			user.Username = username
+		}
+	}
+
+	var verificationToken string
+	if input.Email != nil {
+		email := strings.TrimSpace(*input.Email)
+		if _, err := mail.ParseAddress(email); err != nil {
+			return nil, errors.New("invalid email format")
+		}
+		if email != user.Email {
+			if err := s.confirmPassword(user, input.CurrentPassword); err != nil {
+				return nil, errors.New("current password is incorrect")
+			}
+			if err := s.ensureAvailable(ctx, "email", email, userID); err != nil {
+				return nil, err
+			}
+
+			verificationToken, err = randomHex(32)
+			if err != nil {
+				return nil, err
+			}
+			expiry := time.Now().Add(emailVerificationTTL)
+			user.PendingEmail = email
+			user.EmailVerificationHash = hashSecret(verificationToken)
+			user.EmailVerificationExpiry = &expiry
+		}
+	}
+
+	if err := s.db.WithContext(ctx).Save(user).Error; err != nil {
+		return nil, err
+	}
+
+	if verificationToken != "" {
+		if err := s.sendEmailVerification(ctx, user, verificationToken); err != nil {
+			return nil, err
+		}
+		if err := s.sendEmailChangeNotice(ctx, user); err != nil {
+			return nil, err
+		}
+	}
+
+	return user, nil
+}
+
+// VerifyEmail confirms a pending email change
+func (s *UserService) VerifyEmail(ctx context.Context, token string) (*models.User, error) {
+	if token == "" {
+		return nil, ErrInvalidVerificationToken
+	}
+
+	var user models.User
+	if err := s.db.WithContext(ctx).Where("email_verification_hash = ?", hashSecret(token)).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidVerificationToken
+		}
+		return nil, err
+	}
+
+	if user.PendingEmail == "" || user.EmailVerificationExpiry == nil || time.Now().After(*user.EmailVerificationExpiry) {
+		return nil, ErrInvalidVerificationToken
+	}
+
+	// The address might have been taken since the change was requested
+	if err := s.ensureAvailable(ctx, "email", user.PendingEmail, user.ID); err != nil {
+		return nil, err
+	}
+
+	user.Email = user.PendingEmail
+	user.PendingEmail = ""
+	user.EmailVerificationHash = ""
+	user.EmailVerificationExpiry = nil
+
+	if err := s.db.WithContext(ctx).Save(&user).Error; err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// ChangePassword replaces the password after checking the current one, or sets the
+// first password of a single sign-on user. Existing login tokens are revoked, so the
+// caller should issue a fresh one.
+func (s *UserService) ChangePassword(ctx context.Context, userID int64, input *models.PasswordChangeInput) (*models.User, error) {
+	user, err := s.GetUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.confirmPassword(user, input.CurrentPassword); err != nil {
+		return nil, errors.New("current password is incorrect")
+	}
+
+	return s.authService.SetPassword(ctx, userID, input.NewPassword)
+}
+
+// DeleteAccount removes a user after checking their password, if they have one. The user's tasks
+// are deleted, or anonymized and kept when mode is anonymize. Contact form messages are left
+// alone: they are only matched by an email address nobody has verified, so they may well
+// have been sent by someone else.
+func (s *UserService) DeleteAccount(ctx context.Context, userID int64, password, mode string) error {
+	if mode != DeletionModeDelete && mode != DeletionModeAnonymize {
+		return fmt.Errorf("invalid deletion mode: %s", mode)
+	}
+
+	user, err := s.GetUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.confirmPassword(user, password); err != nil {
+		return errors.New("password is incorrect")
+	}
+
+	var exports []models.DataExport
+	if err := s.db.WithContext(ctx).Where("user_id = ?", userID).Find(&exports).Error; err != nil {
+		return err
+	}
+
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if mode == DeletionModeDelete {
+			if err := tx.Where("user_id = ?", userID).Delete(&models.Task{}).Error; err != nil {
+				return err
+			}
+		} else {
+			// Anonymized tasks belong to nobody and are no longer reachable through the API
+			err := tx.Model(&models.Task{}).Where("user_id = ?", userID).
+				Updates(map[string]interface{}{"user_id": 0, "guest_id": ""}).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		// Contact messages assigned to a deleted admin go back to the unassigned pool
+		if err := tx.Model(&models.Contact{}).Where("assignee_id = ?", userID).Update("assignee_id", nil).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", userID).Delete(&models.APIKey{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", userID).Delete(&models.DataExport{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", userID).Delete(&models.Identity{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", userID).Delete(&models.MagicLinkToken{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("key = ?", userKey(userID)).Delete(&models.LoginAttempt{}).Error; err != nil {
+			return err
+		}
+
+		return tx.Delete(&models.User{}, userID).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	// Export archives hold personal data too
+	for _, export := range exports {
+		if export.FilePath != "" {
+			if err := os.Remove(export.FilePath); err != nil && !os.IsNotExist(err) {
+				logging.FromContext(ctx).Error("Error removing data export", "export_id", export.ID, "error", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// ensureAvailable checks that no other user has the given username or email, ignoring case
+func (s *UserService) ensureAvailable(ctx context.Context, column, value string, userID int64) error {
+	var count int64
+	err := s.db.WithContext(ctx).Model(&models.User{}).
+		Where(column+"_normalized = ? AND id <> ?", models.NormalizeIdentifier(value), userID).
+		Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return fmt.Errorf("%s is already taken", column)
+	}
+	return nil
+}
+
+// confirmPassword checks the password a user entered to confirm a sensitive change.
+// Single sign-on users without a password can't enter one, their login is the proof.
+func (s *UserService) confirmPassword(user *models.User, password string) error {
+	if !user.HasPassword() {
+		return nil
+	}
+	return s.authService.CheckPassword(user, password)
+}
+
+// sendEmailVerification mails the confirmation link for a pending email change
+func (s *UserService) sendEmailVerification(ctx context.Context, user *models.User, token string) error {
+	link := s.baseURL + "/api/users/verify-email?token=" + url.QueryEscape(token)
+
+	msg, err := s.templates.Render(EmailVerification, "", map[string]interface{}{
+		"Username": user.Username,
+		"Link":     link,
+	})
+	if err != nil {
+		return err
+	}
+	msg.To = []string{user.PendingEmail}
+
+	return s.mailer.Send(msg)
+}
+
+// sendEmailChangeNotice tells the current address that a change to another one was
+// requested, so the owner notices if it wasn't them
+func (s *UserService) sendEmailChangeNotice(ctx context.Context, user *models.User) error {
+	msg, err := s.templates.Render(EmailChangeNotice, "", map[string]interface{}{
+		"Username": user.Username,
+		"NewEmail": user.PendingEmail,
+	})
+	if err != nil {
+		return err
+	}
+	msg.To = []string{user.Email}
+
+	return s.mailer.Send(msg)
+}
@@ -14,9 +14,20 @@ try {
   localStorage.removeItem("current_user");
 }
 
-// Check if user is logged in
+// Check if user is logged in. With cookie sessions there is no token in the page,
+// only the user returned at login.
 function isLoggedIn() {
-  return !!authToken;
+  return !!authToken || !!currentUser;
+}
+
+// Keep the token if the server returned one; cookie sessions don't expose it
+function saveAuthToken(token) {
+  authToken = token || null;
+  if (authToken) {
+    localStorage.setItem("auth_token", authToken);
+  } else {
+    localStorage.removeItem("auth_token");
+  }
 }
 
 // Set up login form
@@ -24,6 +35,21 @@ document.addEventListener("DOMContentLoaded", function () {
   // Add login/register forms to the page if they don't exist
   setupAuthForms();
 
+  // Pick up the result of a single sign-on redirect or a sign-in link
+  handleSSORedirect();
+  handleMagicLink();
+
+  // Offer single sign-on when the server has a provider configured
+  loadAuthProviders();
+
+  // Only offer sign-in links when the server sends them
+  loadFeatures().then((features) => {
+    const magicLinkBtn = document.getElementById("request-magic-link");
+    if (magicLinkBtn && !featureEnabled(features, "magic_links")) {
+      magicLinkBtn.parentElement.classList.add("hidden");
+    }
+  });
+
   // Update UI based on auth state
   updateAuthUI();
 
@@ -62,6 +88,11 @@ document.addEventListener("DOMContentLoaded", function () {
     registerForm.addEventListener("submit", handleRegister);
   }
 
+  const magicLinkBtn = document.getElementById("request-magic-link");
+  if (magicLinkBtn) {
+    magicLinkBtn.addEventListener("click", requestMagicLink);
+  }
+
   // Logout button
   const logoutBtn = document.getElementById("logout-btn");
   if (logoutBtn) {
@@ -93,6 +124,8 @@ function setupAuthForms() {
             <button type="submit" class="btn">Login</button>
             <div class="form-status"></div>
           </form>
+          <p><a href="#" id="request-magic-link">Email me a sign-in link instead</a></p>
+          <div id="auth-providers" class="auth-providers hidden"></div>
           <p>Don't have an account? <a href="#" id="show-register">Register</a></p>
         </div>
         
@@ -109,8 +142,8 @@ function setupAuthForms() {
             </div>
             <div class="form-group">
               <label for="register-password">Password <span class="required">*</span></label>
-              <input type="password" id="register-password" required minlength="6">
-              <small class="form-hint">Password must be at least 6 characters long</small>
+              <input type="password" id="register-password" required>
+              <small class="form-hint">Choose a long password you don't use on other sites</small>
             </div>
             <button type="submit" class="btn">Register</button>
             <div class="form-status"></div>
@@ -164,6 +197,135 @@ function setupAuthForms() {
   }
 }
 
+// Read the token or error the server puts in the URL fragment after single sign-on
+function handleSSORedirect() {
+  const params = new URLSearchParams(window.location.hash.slice(1));
+  const token = params.get("sso_token");
+  const session = params.get("sso_session");
+  const error = params.get("sso_error");
+  if (!token && !session && !error) {
+    return;
+  }
+
+  // Remove the token from the address bar and history
+  history.replaceState(null, "", window.location.pathname + window.location.search);
+
+  if (error) {
+    showNotification(error, "error");
+    return;
+  }
+
+  saveAuthToken(token);
+
+  fetchWithAuth("/api/users/me")
+    .then((response) => {
+      if (!response.ok) {
+        throw new Error("Failed to load your profile");
+      }
+      return response.json();
+    })
+    .then((user) => {
+      currentUser = user;
+      localStorage.setItem("current_user", JSON.stringify(currentUser));
+      updateAuthUI();
+      showNotification("Login successful!");
+      fetchTasks();
+    })
+    .catch((error) => {
+      console.error("SSO login error:", error);
+      showNotification(error.message, "error");
+    });
+}
+
+// Redeem a sign-in link opened from an email
+function handleMagicLink() {
+  const params = new URLSearchParams(window.location.hash.slice(1));
+  const token = params.get("magic_link");
+  if (!token) {
+    return;
+  }
+
+  // Remove the token from the address bar and history
+  history.replaceState(null, "", window.location.pathname + window.location.search);
+
+  fetch(`/api/auth/magic-link/callback?token=${encodeURIComponent(token)}`, {
+    headers: { Accept: "application/json" },
+  })
+    .then(async (response) => {
+      const data = await response.json();
+      if (!response.ok) {
+        throw new Error(data.error || "Sign-in link is invalid");
+      }
+      return data;
+    })
+    .then((data) => {
+      saveAuthToken(data.token);
+      currentUser = data.user;
+      localStorage.setItem("current_user", JSON.stringify(currentUser));
+      updateAuthUI();
+      showNotification("Login successful!");
+      fetchTasks();
+    })
+    .catch((error) => {
+      console.error("Sign-in link error:", error);
+      showNotification(error.message, "error");
+    });
+}
+
+// Ask for a sign-in link for the email address typed into the login form
+async function requestMagicLink(e) {
+  e.preventDefault();
+
+  const email = document.getElementById("login-username").value.trim();
+  if (!email.includes("@")) {
+    showNotification("Enter your email address first", "error");
+    return;
+  }
+
+  try {
+    const response = await fetch("/api/auth/magic-link", {
+      method: "POST",
+      headers: { "Content-Type": "application/json" },
+      body: JSON.stringify({ email: email }),
+    });
+    const data = await response.json();
+    if (!response.ok) {
+      throw new Error(data.error || "Failed to send sign-in link");
+    }
+    showNotification(data.message);
+  } catch (error) {
+    console.error("Sign-in link error:", error);
+    showNotification(error.message, "error");
+  }
+}
+
+// Add a button for each external login provider
+async function loadAuthProviders() {
+  const container = document.getElementById("auth-providers");
+  if (!container) {
+    return;
+  }
+
+  try {
+    const response = await fetch("/api/auth/providers");
+    if (!response.ok) {
+      return;
+    }
+    const providers = await response.json();
+
+    providers.forEach((provider) => {
+      const link = document.createElement("a");
+      link.href = provider.login_url;
+      link.className = "btn";
+      link.textContent = `Log in with ${provider.name}`;
+      container.appendChild(link);
+    });
+    container.classList.toggle("hidden", providers.length === 0);
+  } catch (error) {
+    console.error("Error loading login providers:", error);
+  }
+}
+
 // Update UI based on authentication state
 function updateAuthUI() {
   const authContainer = document.getElementById("auth-container");
@@ -249,7 +411,7 @@ async function handleLogin(e) {
         "Content-Type": "application/json",
       },
       body: JSON.stringify({
-        username: username,
+        identifier: username,
         password: password,
       }),
     });
@@ -262,10 +424,9 @@ async function handleLogin(e) {
     const data = await response.json();
 
     // Save auth token and user info
-    authToken = data.token;
+    saveAuthToken(data.token);
     currentUser = data.user;
 
-    localStorage.setItem("auth_token", authToken);
     localStorage.setItem("current_user", JSON.stringify(currentUser));
 
     // Update status
@@ -313,15 +474,7 @@ async function handleRegister(e) {
   const email = document.getElementById("register-email").value;
   const password = document.getElementById("register-password").value;
 
-  // Basic validation
-  if (password.length < 6) {
-    statusElement.textContent = "Password must be at least 6 characters long";
-    statusElement.className = "form-status status-error";
-    submitButton.disabled = false;
-    submitButton.textContent = "Register";
-    return;
-  }
-
+  // The server checks the password against its policy and says what is missing
   try {
     const response = await fetch("/api/auth/register", {
       method: "POST",
@@ -343,10 +496,9 @@ async function handleRegister(e) {
     const data = await response.json();
 
     // Save auth token and user info
-    authToken = data.token;
+    saveAuthToken(data.token);
     currentUser = data.user;
 
-    localStorage.setItem("auth_token", authToken);
     localStorage.setItem("current_user", JSON.stringify(currentUser));
 
     // Update status
@@ -380,10 +532,14 @@ async function handleRegister(e) {
 function handleLogout(e) {
   e.preventDefault();
 
+  // End the cookie session, if any
+  fetchWithAuth("/api/auth/logout", { method: "POST" }).catch((error) =>
+    console.error("Logout error:", error)
+  );
+
   // Clear auth data
-  authToken = null;
+  saveAuthToken(null);
   currentUser = null;
-  localStorage.removeItem("auth_token");
   localStorage.removeItem("current_user");
 
   // Update UI
@@ -417,6 +573,12 @@ function fetchWithAuth(url, options = {}) {
     options.headers.Authorization = `Bearer ${authToken}`;
   }
 
+  // Cookie sessions need the CSRF token on changes
+  const csrfToken = getCookie("csrf_token");
+  if (csrfToken && options.method && options.method !== "GET") {
+    options.headers["X-CSRF-Token"] = csrfToken;
+  }
+
   // Add default content type if not provided
   if (!options.headers["Content-Type"] && options.method !== "GET") {
     options.headers["Content-Type"] = "application/json";
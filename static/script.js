@@ -1,4 +1,37 @@
+// Read a cookie by name
+function getCookie(name) {
+  const match = document.cookie
+    .split("; ")
+    .find((cookie) => cookie.startsWith(name + "="));
+  return match ? decodeURIComponent(match.slice(name.length + 1)) : "";
+}
+
+// Which optional features the server has enabled, fetched once. If that fails every
+// feature is shown; the server still refuses the disabled ones.
+let featuresRequest;
+function loadFeatures() {
+  if (!featuresRequest) {
+    featuresRequest = fetch("/api/features")
+      .then((response) => (response.ok ? response.json() : {}))
+      .catch(() => ({}));
+  }
+  return featuresRequest;
+}
+
+function featureEnabled(features, name) {
+  return features[name] !== false;
+}
+
 document.addEventListener("DOMContentLoaded", function () {
+  // Hide the contact section and its links when the contact form is disabled
+  loadFeatures().then((features) => {
+    if (!featureEnabled(features, "contact_form")) {
+      document
+        .querySelectorAll('#contact, a[href="#contact"]')
+        .forEach((element) => element.classList.add("hidden"));
+    }
+  });
+
   // UI enhancement variables
   let taskCount = 0;
   const notificationTimeout = 3000;
@@ -50,7 +83,7 @@ document.addEventListener("DOMContentLoaded", function () {
     }
 
     // Update labels to indicate required fields
-    const labels = form.querySelectorAll("label");
+    const labels = form.querySelectorAll(".form-group label");
     labels.forEach((label) => {
       if (!label.querySelector(".required")) {
         label.innerHTML += ' <span class="required">*</span>';
@@ -58,16 +91,34 @@ document.addEventListener("DOMContentLoaded", function () {
     });
 
     // Add required attribute to all inputs
-    const inputs = form.querySelectorAll("input, textarea");
+    const inputs = form.querySelectorAll(
+      ".form-group input, .form-group textarea"
+    );
     inputs.forEach((input) => {
       input.setAttribute("required", "true");
     });
 
+    // The server only accepts the form with a token issued when it was loaded
+    let formToken = "";
+    function loadFormToken() {
+      fetch("/api/contact/token")
+        .then((response) => (response.ok ? response.json() : {}))
+        .then((data) => {
+          formToken = data.token || "";
+        })
+        .catch((error) => {
+          console.error("Error loading contact form token:", error);
+        });
+    }
+    loadFormToken();
+
     form.addEventListener("submit", function (e) {
       e.preventDefault();
       const name = document.getElementById("name").value;
       const email = document.getElementById("email").value;
       const message = document.getElementById("message").value;
+      const honeypot = document.getElementById("website");
+      const website = honeypot ? honeypot.value : "";
       const submitBtn = form.querySelector(".submit-btn");
       const statusElement = form.querySelector(".form-status");
 
@@ -84,7 +135,13 @@ document.addEventListener("DOMContentLoaded", function () {
           headers: {
             "Content-Type": "application/json",
           },
-          body: JSON.stringify({ name, email, message }),
+          body: JSON.stringify({
+            name,
+            email,
+            message,
+            website,
+            form_token: formToken,
+          }),
         })
           .then((response) => {
             // First check if response is ok before trying to parse JSON
@@ -148,6 +205,7 @@ document.addEventListener("DOMContentLoaded", function () {
             // Reset button state
             submitBtn.disabled = false;
             submitBtn.textContent = "Send Message";
+            loadFormToken();
           });
       } else {
         statusElement.textContent = "Please fill out all required fields.";
@@ -400,6 +458,12 @@ document.addEventListener("DOMContentLoaded", function () {
           headers["Authorization"] = `Bearer ${authToken}`;
         }
 
+        // Cookie sessions need the CSRF token on changes
+        const csrfToken = getCookie("csrf_token");
+        if (csrfToken) {
+          headers["X-CSRF-Token"] = csrfToken;
+        }
+
         // Send to API
         const response = await fetch("/api/tasks", {
           method: "POST",
@@ -451,6 +515,12 @@ document.addEventListener("DOMContentLoaded", function () {
         headers["Authorization"] = `Bearer ${authToken}`;
       }
 
+      // Cookie sessions need the CSRF token on changes
+      const csrfToken = getCookie("csrf_token");
+      if (csrfToken) {
+        headers["X-CSRF-Token"] = csrfToken;
+      }
+
       // Delete from API
       const response = await fetch(`/api/tasks/${id}`, {
         method: "DELETE",
@@ -510,6 +580,12 @@ document.addEventListener("DOMContentLoaded", function () {
         headers["Authorization"] = `Bearer ${authToken}`;
       }
 
+      // Cookie sessions need the CSRF token on changes
+      const csrfToken = getCookie("csrf_token");
+      if (csrfToken) {
+        headers["X-CSRF-Token"] = csrfToken;
+      }
+
       // Update in API
       const response = await fetch(`/api/tasks/${id}`, {
         method: "PUT",